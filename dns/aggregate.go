@@ -0,0 +1,314 @@
+package dns
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A DMARC aggregate (rua=) report, see https://tools.ietf.org/html/rfc7489 appendix C
+type AggregateReport struct {
+	OrgName  string
+	Email    string
+	ReportID string
+	Begin    time.Time
+	End      time.Time
+	Errors   []string
+
+	Policy *DMARCProfile //policy_published
+	Rows   []AggregateRow
+}
+
+// The messages from one source IP which were evaluated the same way
+type AggregateRow struct {
+	IP    net.IP
+	Count int64
+
+	//policy_evaluated, SPF and DKIM are the aligned results DMARC used
+	Disposition string
+	SPF         int
+	DKIM        int
+	Reasons     []string //e.g. "forwarded", "mailing_list"
+
+	HeaderFrom   string
+	EnvelopeFrom string
+
+	//auth_results, the raw results before alignment
+	SPFResults  []DMARCAuth
+	DKIMResults []DMARCAuth
+}
+
+// The XML schema, only the elements we use
+type aggregateXML struct {
+	Metadata struct {
+		OrgName  string   `xml:"org_name"`
+		Email    string   `xml:"email"`
+		ReportID string   `xml:"report_id"`
+		Begin    int64    `xml:"date_range>begin"`
+		End      int64    `xml:"date_range>end"`
+		Errors   []string `xml:"error"`
+	} `xml:"report_metadata"`
+	Policy struct {
+		Domain string `xml:"domain"`
+		ADKIM  string `xml:"adkim"`
+		ASPF   string `xml:"aspf"`
+		P      string `xml:"p"`
+		SP     string `xml:"sp"`
+		PCT    int64  `xml:"pct"`
+		FO     string `xml:"fo"`
+	} `xml:"policy_published"`
+	Records []struct {
+		SourceIP    string `xml:"row>source_ip"`
+		Count       int64  `xml:"row>count"`
+		Disposition string `xml:"row>policy_evaluated>disposition"`
+		DKIM        string `xml:"row>policy_evaluated>dkim"`
+		SPF         string `xml:"row>policy_evaluated>spf"`
+		Reasons     []struct {
+			Type string `xml:"type"`
+		} `xml:"row>policy_evaluated>reason"`
+		HeaderFrom   string `xml:"identifiers>header_from"`
+		EnvelopeFrom string `xml:"identifiers>envelope_from"`
+		AuthDKIM     []struct {
+			Domain string `xml:"domain"`
+			Result string `xml:"result"`
+		} `xml:"auth_results>dkim"`
+		AuthSPF []struct {
+			Domain string `xml:"domain"`
+			Result string `xml:"result"`
+		} `xml:"auth_results>spf"`
+	} `xml:"record"`
+}
+
+// Reports come from anyone who can send us mail, so decompression is capped to stop a compression bomb
+const maxReportSize = 32 << 20
+
+/*
+   Parses an aggregate report as received, either plain XML or a .gz or .zip attachment.
+   A .zip is expected to hold a single XML report, of no more than maxReportSize bytes once decompressed.
+*/
+func ParseAggregateReport(data []byte) (*AggregateReport, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		if data, err = readReport(gz); err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		var file *zip.File
+		for _, f := range zr.File {
+			if strings.HasSuffix(strings.ToLower(f.Name), ".xml") {
+				file = f
+				break
+			}
+		}
+		if file == nil {
+			return nil, fmt.Errorf("zip attachment has no XML report")
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		if data, err = readReport(rc); err != nil {
+			return nil, err
+		}
+	}
+
+	var x aggregateXML
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return nil, err
+	}
+
+	rep := AggregateReport{
+		OrgName:  x.Metadata.OrgName,
+		Email:    x.Metadata.Email,
+		ReportID: x.Metadata.ReportID,
+		Begin:    time.Unix(x.Metadata.Begin, 0).UTC(),
+		End:      time.Unix(x.Metadata.End, 0).UTC(),
+		Errors:   x.Metadata.Errors,
+		Policy: &DMARCProfile{V: 1, Domain: normalDomain(x.Policy.Domain), ADKIM: x.Policy.ADKIM, ASPF: x.Policy.ASPF,
+			P: x.Policy.P, SP: x.Policy.SP, PCT: x.Policy.PCT, FO: x.Policy.FO},
+	}
+
+	for _, rec := range x.Records {
+		ip := net.ParseIP(strings.TrimSpace(rec.SourceIP))
+		if ip == nil {
+			return nil, fmt.Errorf("report %s has an invalid source_ip %q", rep.ReportID, rec.SourceIP)
+		}
+
+		row := AggregateRow{IP: ip, Count: rec.Count, Disposition: rec.Disposition,
+			SPF: authResult(rec.SPF), DKIM: authResult(rec.DKIM),
+			HeaderFrom: normalDomain(rec.HeaderFrom), EnvelopeFrom: normalDomain(rec.EnvelopeFrom)}
+		for _, reason := range rec.Reasons {
+			row.Reasons = append(row.Reasons, reason.Type)
+		}
+		for _, a := range rec.AuthSPF {
+			row.SPFResults = append(row.SPFResults, DMARCAuth{authResult(a.Result), normalDomain(a.Domain)})
+		}
+		for _, a := range rec.AuthDKIM {
+			row.DKIMResults = append(row.DKIMResults, DMARCAuth{authResult(a.Result), normalDomain(a.Domain)})
+		}
+		rep.Rows = append(rep.Rows, row)
+	}
+
+	return &rep, nil
+}
+
+func readReport(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxReportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxReportSize {
+		return nil, fmt.Errorf("report exceeds %d bytes decompressed", maxReportSize)
+	}
+	return data, nil
+}
+
+// "pass", "softfail" etc. as used in SPFResults, anything else (e.g. DKIM's "policy") is UNDEF
+func authResult(s string) int {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for result, name := range SPFResults {
+		if name == s {
+			return result
+		}
+	}
+	return UNDEF
+}
+
+// Everything the reports say about one sending IP
+type AggregateSource struct {
+	IP net.IP
+
+	Count     int64 //messages
+	SPFPass   int64 //with an aligned SPF pass
+	DKIMPass  int64 //with an aligned DKIM pass
+	DMARCFail int64 //with neither
+
+	Dispositions  map[string]int64
+	Reasons       map[string]int64
+	EnvelopeFroms []string
+	DKIMDomains   []string //which passed DKIM
+}
+
+// Merges the rows of every report by source IP, the IPs sending the most failing mail first
+func AggregateSources(reports []*AggregateReport) []*AggregateSource {
+	byIP := make(map[string]*AggregateSource)
+
+	for _, rep := range reports {
+		for _, row := range rep.Rows {
+			s := byIP[row.IP.String()]
+			if s == nil {
+				s = &AggregateSource{IP: row.IP, Dispositions: make(map[string]int64), Reasons: make(map[string]int64)}
+				byIP[row.IP.String()] = s
+			}
+
+			s.Count += row.Count
+			if row.SPF == PASS {
+				s.SPFPass += row.Count
+			}
+			if row.DKIM == PASS {
+				s.DKIMPass += row.Count
+			}
+			if row.SPF != PASS && row.DKIM != PASS {
+				s.DMARCFail += row.Count
+			}
+			s.Dispositions[row.Disposition] += row.Count
+			for _, reason := range row.Reasons {
+				s.Reasons[reason] += row.Count
+			}
+
+			if row.EnvelopeFrom != "" && !contains(s.EnvelopeFroms, row.EnvelopeFrom) {
+				s.EnvelopeFroms = append(s.EnvelopeFroms, row.EnvelopeFrom)
+			}
+			for _, d := range row.DKIMResults {
+				if d.Result == PASS && !contains(s.DKIMDomains, d.Domain) {
+					s.DKIMDomains = append(s.DKIMDomains, d.Domain)
+				}
+			}
+		}
+	}
+
+	var sources []*AggregateSource
+	for _, s := range byIP {
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].DMARCFail != sources[j].DMARCFail {
+			return sources[i].DMARCFail > sources[j].DMARCFail
+		}
+		if sources[i].Count != sources[j].Count {
+			return sources[i].Count > sources[j].Count
+		}
+		return bytes.Compare(sources[i].IP.To16(), sources[j].IP.To16()) < 0
+	})
+	return sources
+}
+
+// Why a source is failing DMARC, as far as the domain's SPF record can tell
+type SPFFinding struct {
+	Source *AggregateSource
+	Result int //of the domain's current SPF record for the source IP
+
+	Reason string
+}
+
+/*
+   Checks each source which failed DMARC for some messages against p, the domain's current SPF record.
+   The record is evaluated with CheckHost, so includes and macros are taken into account.
+*/
+func CrossReferenceSPF(r Resolver, p *SPFProfile, sources []*AggregateSource) (findings []SPFFinding) {
+	for _, s := range sources {
+		if s.DMARCFail == 0 {
+			continue
+		}
+
+		f := SPFFinding{Source: s}
+		f.Result, _, _ = EvaluateSPF(r, p.Record, s.IP, p.Domain, "postmaster@"+p.Domain, "")
+
+		var foreign []string
+		for _, d := range s.EnvelopeFroms {
+			if !sameOrg(d, p.Domain) {
+				foreign = append(foreign, d)
+			}
+		}
+
+		switch {
+		case s.Reasons["forwarded"] > 0 || s.Reasons["mailing_list"] > 0:
+			f.Reason = "forwarded or sent through a mailing list, only DKIM can survive this"
+		case f.Result != PASS:
+			f.Reason = fmt.Sprintf("not authorised by the SPF record of %s (%s)", p.Domain, SPFResults[f.Result])
+		case len(foreign) > 0:
+			f.Reason = fmt.Sprintf("authorised by SPF, but MAIL FROM used %s which isn't aligned with %s",
+				strings.Join(foreign, ", "), p.Domain)
+		default:
+			f.Reason = "authorised by the current SPF record, which may have changed since the report"
+		}
+
+		if s.DKIMPass == 0 {
+			if len(s.DKIMDomains) > 0 {
+				f.Reason += fmt.Sprintf("; DKIM passed for %s but isn't aligned", strings.Join(s.DKIMDomains, ", "))
+			} else {
+				f.Reason += "; no DKIM signature passed"
+			}
+		}
+
+		findings = append(findings, f)
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package dns
+
+import (
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "net"
+    "reflect"
+    "testing"
+    "time"
+)
+
+const aggregateXMLReport = `<?xml version="1.0" encoding="UTF-8" ?>
+<feedback>
+  <report_metadata>
+    <org_name>google.com</org_name>
+    <email>noreply-dmarc-support@google.com</email>
+    <report_id>4410263446712543795</report_id>
+    <date_range><begin>1464739200</begin><end>1464825599</end></date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>bank.com</domain><adkim>r</adkim><aspf>r</aspf><p>reject</p><sp>reject</sp><pct>100</pct>
+  </policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.10</source_ip><count>40</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>pass</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>bank.com</header_from><envelope_from>bank.com</envelope_from></identifiers>
+    <auth_results>
+      <dkim><domain>bank.com</domain><selector>s1</selector><result>pass</result></dkim>
+      <spf><domain>bank.com</domain><scope>mfrom</scope><result>pass</result></spf>
+    </auth_results>
+  </record>
+  <record>
+    <row>
+      <source_ip>203.0.113.99</source_ip><count>7</count>
+      <policy_evaluated><disposition>reject</disposition><dkim>fail</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>bank.com</header_from><envelope_from>bank.com</envelope_from></identifiers>
+    <auth_results>
+      <spf><domain>bank.com</domain><result>fail</result></spf>
+    </auth_results>
+  </record>
+  <record>
+    <row>
+      <source_ip>198.51.100.7</source_ip><count>3</count>
+      <policy_evaluated><disposition>reject</disposition><dkim>fail</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>bank.com</header_from><envelope_from>bounces.esp.com</envelope_from></identifiers>
+    <auth_results>
+      <dkim><domain>esp.com</domain><result>pass</result></dkim>
+      <spf><domain>bounces.esp.com</domain><result>pass</result></spf>
+    </auth_results>
+  </record>
+  <record>
+    <row>
+      <source_ip>2001:db8::99</source_ip><count>2</count>
+      <policy_evaluated><disposition>none</disposition><dkim>fail</dkim><spf>fail</spf>
+        <reason><type>forwarded</type></reason></policy_evaluated>
+    </row>
+    <identifiers><header_from>bank.com</header_from></identifiers>
+    <auth_results>
+      <spf><domain>list.example.org</domain><result>softfail</result></spf>
+    </auth_results>
+  </record>
+</feedback>
+`
+
+func TestParseAggregateReport(t *testing.T) {
+    var gz bytes.Buffer
+    w := gzip.NewWriter(&gz)
+    w.Write([]byte(aggregateXMLReport))
+    w.Close()
+
+    var zipped bytes.Buffer
+    z := zip.NewWriter(&zipped)
+    f, _ := z.Create("google.com!bank.com!1464739200!1464825599.xml")
+    f.Write([]byte(aggregateXMLReport))
+    z.Close()
+
+    for _, data := range [][]byte{[]byte(aggregateXMLReport), gz.Bytes(), zipped.Bytes()} {
+        rep, err := ParseAggregateReport(data)
+        if err != nil {
+            t.Errorf("ParseAggregateReport returned %v\n", err)
+            continue
+        }
+
+        if rep.OrgName != "google.com" || rep.ReportID != "4410263446712543795" ||
+           !rep.Begin.Equal(time.Unix(1464739200, 0)) || rep.Policy.P != "reject" || rep.Policy.Domain != "bank.com" {
+            t.Errorf("AggregateReport metadata was %+v\n", rep)
+        }
+
+        want := AggregateRow{IP: net.ParseIP("198.51.100.7"), Count: 3, Disposition: "reject", SPF: FAIL, DKIM: FAIL,
+                             HeaderFrom: "bank.com", EnvelopeFrom: "bounces.esp.com",
+                             SPFResults: []DMARCAuth{{PASS, "bounces.esp.com"}}, DKIMResults: []DMARCAuth{{PASS, "esp.com"}}}
+        if len(rep.Rows) != 4 || !reflect.DeepEqual(rep.Rows[2], want) {
+            t.Errorf("AggregateReport rows were %+v \n want %+v as the third\n", rep.Rows, want)
+        }
+        if !reflect.DeepEqual(rep.Rows[3].Reasons, []string{"forwarded"}) {
+            t.Errorf("AggregateRow reasons were %q\n", rep.Rows[3].Reasons)
+        }
+    }
+
+    if _, err := ParseAggregateReport([]byte("<feedback><record><row><source_ip>bogus</source_ip></row></record></feedback>")); err == nil {
+        t.Errorf("ParseAggregateReport accepted an invalid source_ip\n")
+    }
+
+    //a compression bomb is refused rather than read into memory
+    var bomb bytes.Buffer
+    w = gzip.NewWriter(&bomb)
+    w.Write(make([]byte, maxReportSize+1))
+    w.Close()
+    if _, err := ParseAggregateReport(bomb.Bytes()); err == nil {
+        t.Errorf("ParseAggregateReport accepted %d bytes decompressed\n", maxReportSize+1)
+    }
+}
+
+func TestCrossReferenceSPF(t *testing.T) {
+    rep, _ := ParseAggregateReport([]byte(aggregateXMLReport))
+    sources := AggregateSources([]*AggregateReport{rep, rep})
+
+    if len(sources) != 4 || sources[0].IP.String() != "203.0.113.99" || sources[0].DMARCFail != 14 ||
+       sources[3].IP.String() != "192.0.2.10" || sources[3].Count != 80 || sources[3].SPFPass != 80 {
+        t.Fatalf("AggregateSources were %+v\n", sources)
+    }
+
+    findings := CrossReferenceSPF(testResolver, ResolveSPF(testResolver, "bank.com", ""), sources)
+    want := []string{
+        "not authorised by the SPF record of bank.com (fail); no DKIM signature passed",
+        "authorised by SPF, but MAIL FROM used bounces.esp.com which isn't aligned with bank.com; DKIM passed for esp.com but isn't aligned",
+        "forwarded or sent through a mailing list, only DKIM can survive this; no DKIM signature passed",
+    }
+    var reasons []string
+    for _, f := range findings {
+        reasons = append(reasons, f.Reason)
+    }
+    if !reflect.DeepEqual(reasons, want) {
+        t.Errorf("CrossReferenceSPF found %q \n want %q\n", reasons, want)
+    }
+}
@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// How much a DKIM key can be trusted
+const (
+	KEY_BROKEN  = iota //0, revoked, unparseable or under 1024 bits
+	KEY_WEAK           //1, RSA under 2048 bits
+	KEY_STRONG         //2
+)
+
+var KeyStrengths = map[int]string{
+	KEY_BROKEN: "broken",
+	KEY_WEAK:   "weak",
+	KEY_STRONG: "strong",
+}
+
+// What the p=, k=, h= and s= tags of a DKIM key record amount to
+type DKIMKey struct {
+	Type     string   //"rsa" or "ed25519"
+	Bits     int      //the RSA modulus, 256 for Ed25519
+	Revoked  bool     //p= is empty
+	Hashes   []string //h=, every hash is allowed if empty
+	Services []string //s=, "*" or "email"
+	Strength int
+
+	Public crypto.PublicKey
+	Err    error //why the key couldn't be parsed
+}
+
+/*
+   Parses the public key of a DKIM key record, see https://tools.ietf.org/html/rfc6376 section 3.6.1
+   and https://tools.ietf.org/html/rfc8463 for Ed25519 keys, which are the raw 32 bytes rather than a SubjectPublicKeyInfo.
+*/
+func ParseDKIMKey(p *DKIMDNSProfile) *DKIMKey {
+	k := DKIMKey{Type: strings.ToLower(p.K), Strength: KEY_BROKEN}
+	if k.Type == "" {
+		k.Type = "rsa"
+	}
+	if p.H != "" {
+		k.Hashes = strings.Split(strings.ToLower(p.H), ":")
+	}
+	k.Services = []string{"*"}
+	if p.S != "" {
+		k.Services = strings.Split(strings.ToLower(p.S), ":")
+	}
+
+	if p.P == "" {
+		k.Revoked = true
+		k.Err = errors.New("key has been revoked")
+		return &k
+	}
+
+	der, err := b64.StdEncoding.DecodeString(p.P)
+	if err != nil {
+		k.Err = fmt.Errorf("p= is not valid base64: %s", err)
+		return &k
+	}
+
+	switch k.Type {
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			k.Err = fmt.Errorf("ed25519 key is %d bytes", len(der))
+			return &k
+		}
+		k.Public, k.Bits, k.Strength = ed25519.PublicKey(der), 256, KEY_STRONG
+	case "rsa":
+		//keys should be a SubjectPublicKeyInfo, but some publish a bare RSAPublicKey
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			if rsaPub, rsaErr := x509.ParsePKCS1PublicKey(der); rsaErr == nil {
+				pub, err = rsaPub, nil
+			}
+		}
+		if err != nil {
+			k.Err = err
+			return &k
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			k.Err = errors.New("p= is not an RSA key")
+			return &k
+		}
+		k.Public, k.Bits = rsaPub, rsaPub.N.BitLen()
+
+		//See https://tools.ietf.org/html/rfc8301 section 3.2
+		switch {
+		case k.Bits < 1024:
+			k.Err = fmt.Errorf("%d bit RSA keys can be factored", k.Bits)
+		case k.Bits < 2048:
+			k.Strength = KEY_WEAK
+		default:
+			k.Strength = KEY_STRONG
+		}
+	default:
+		k.Err = fmt.Errorf("unknown key type k=%s", p.K)
+	}
+
+	return &k
+}
+
+// Whether the key may be used with hash, "sha1" or "sha256"
+func (k *DKIMKey) AllowsHash(hash string) bool {
+	return len(k.Hashes) == 0 || contains(k.Hashes, hash)
+}
+
+// Whether the key may be used to sign email at all
+func (k *DKIMKey) ForEmail() bool {
+	return contains(k.Services, "*") || contains(k.Services, "email")
+}
+
+/*
+   Picks the key record out of the TXT records at <selector>._domainkey.<domain>. Exactly one of them
+   has to look like a key (v=DKIM1 or a p= tag): with several the key is ambiguous and can't be used,
+   see https://tools.ietf.org/html/rfc6376 section 3.6.2.2.
+*/
+func DKIMKeyRecord(txts []string) (string, error) {
+	var keys []string
+	for _, txt := range txts {
+		params := parseParams(txt)
+		if _, ok := params["p"]; ok || strings.EqualFold(params["v"], "DKIM1") {
+			keys = append(keys, txt)
+		}
+	}
+
+	switch len(keys) {
+	case 0:
+		return "", errors.New("no key record")
+	case 1:
+		return keys[0], nil
+	default:
+		return "", fmt.Errorf("%d key records", len(keys))
+	}
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+    "reflect"
+    "testing"
+)
+
+var ParseDKIMKeyTests = []struct {
+    p *DKIMDNSProfile
+
+    keyType  string
+    bits     int
+    strength int
+    err      bool
+}{
+    {p: ParseDKIMDNS("k=rsa; p=" + testKey2048), keyType: "rsa", bits: 2048, strength: KEY_STRONG},
+    {p: &DKIMDNSProfile{P: testKey512}, keyType: "rsa", bits: 512, strength: KEY_BROKEN, err: true},
+    {p: ParseDKIMDNS("v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="), keyType: "ed25519", bits: 256, strength: KEY_STRONG},
+    {p: ParseDKIMDNS("v=DKIM1; k=ed25519; p=" + testKey512), keyType: "ed25519", strength: KEY_BROKEN, err: true},
+    {p: ParseDKIMDNS("v=DKIM1; k=dsa; p=" + testKey512), keyType: "dsa", strength: KEY_BROKEN, err: true},
+    {p: ParseDKIMDNS("v=DKIM1; p=not+base64!"), keyType: "rsa", strength: KEY_BROKEN, err: true},
+}
+
+func TestParseDKIMKey(t *testing.T) {
+    for _, tt := range ParseDKIMKeyTests {
+        k := ParseDKIMKey(tt.p)
+        if k.Type != tt.keyType || k.Bits != tt.bits || k.Strength != tt.strength || (k.Err != nil) != tt.err {
+            t.Errorf("DKIMKey for %q was %s %d bits, %s (%v) \n want %s %d bits, %s\n", tt.p.P, k.Type, k.Bits,
+                     KeyStrengths[k.Strength], k.Err, tt.keyType, tt.bits, KeyStrengths[tt.strength])
+        }
+    }
+
+    k := ParseDKIMKey(ParseDKIMDNS("v=DKIM1; h=sha1:sha256; s=email; p="))
+    if !k.Revoked || k.Strength != KEY_BROKEN || !reflect.DeepEqual(k.Hashes, []string{"sha1", "sha256"}) ||
+       !reflect.DeepEqual(k.Services, []string{"email"}) || !k.AllowsHash("sha256") || !k.ForEmail() {
+        t.Errorf("DKIMKey for a revoked key was %+v\n", k)
+    }
+}
+
+var DKIMKeyRecordTests = []struct {
+    txts []string
+
+    record string
+    err    bool
+}{
+    {[]string{"v=DKIM1; p=abc"}, "v=DKIM1; p=abc", false},
+    {[]string{"some-verification=abc", "k=rsa; p=abc"}, "k=rsa; p=abc", false},
+    {[]string{"some-verification=abc"}, "", true},
+    {[]string{"v=DKIM1; p=abc", "v=DKIM1; p=def"}, "", true},
+    {nil, "", true},
+}
+
+func TestDKIMKeyRecord(t *testing.T) {
+    for _, tt := range DKIMKeyRecordTests {
+        record, err := DKIMKeyRecord(tt.txts)
+        if record != tt.record || (err != nil) != tt.err {
+            t.Errorf("DKIMKeyRecord(%q) was %q (%v) \n want %q\n", tt.txts, record, err, tt.record)
+        }
+    }
+}
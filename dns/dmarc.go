@@ -0,0 +1,269 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An SPF or DKIM result, along with the domain it authenticated (MAIL FROM for SPF, d= for DKIM)
+type DMARCAuth struct {
+	Result int
+	Domain string
+}
+
+type DMARCResult struct {
+	Result int //PASS or FAIL, NONE when there's no policy
+
+	SPFAligned  bool
+	DKIMAligned bool
+
+	Policy      string //the p= or sp= which applied
+	Disposition string //"none", "quarantine" or "reject"
+	Sampled     bool   //false when pct= exempted the message from its policy
+}
+
+//replaced in tests, returns 0-99
+var dmarcSample = func() int64 { return rand.Int63n(100) }
+
+/*
+   Evaluates the DMARC policy p for a message with the RFC5322.From domain from,
+   see https://tools.ietf.org/html/rfc7489 section 6.6.
+   The message passes if either SPF or one of the DKIM signatures passed for an aligned domain.
+   p.Domain is where the policy was found, so a from domain below it is governed by sp= when present.
+*/
+func EvaluateDMARC(p *DMARCProfile, from string, spf DMARCAuth, dkim []DMARCAuth) *DMARCResult {
+	res := DMARCResult{Result: FAIL, Sampled: true}
+
+	if p.Discovery != DMARC_FOUND {
+		res.Result, res.Policy, res.Disposition = NONE, "none", "none"
+		return &res
+	}
+
+	res.SPFAligned = spf.Result == PASS && aligned(spf.Domain, from, p.ASPF)
+	for _, d := range dkim {
+		if d.Result == PASS && aligned(d.Domain, from, p.ADKIM) {
+			res.DKIMAligned = true
+		}
+	}
+
+	res.Policy = p.P
+	if p.SP != "" && !strings.EqualFold(normalDomain(from), normalDomain(p.Domain)) {
+		res.Policy = p.SP
+	}
+	//an unknown policy is treated as none
+	if _, ok := dp[res.Policy]; !ok {
+		res.Policy = "none"
+	}
+
+	if res.SPFAligned || res.DKIMAligned {
+		res.Result, res.Disposition = PASS, "none"
+		return &res
+	}
+
+	res.Disposition = res.Policy
+	if p.PCT < 100 && dmarcSample() >= p.PCT {
+		//messages outside the sample get the next policy down
+		res.Sampled = false
+		switch res.Disposition {
+		case "reject":
+			res.Disposition = "quarantine"
+		case "quarantine":
+			res.Disposition = "none"
+		}
+	}
+
+	return &res
+}
+
+// Strict alignment needs the domains to match exactly, relaxed only their organizational domains
+func aligned(domain string, from string, mode string) bool {
+	domain, from = normalDomain(domain), normalDomain(from)
+	if domain == "" || from == "" {
+		return false
+	}
+	if mode == "s" {
+		return domain == from
+	}
+	return OrgDomain(domain) == OrgDomain(from)
+}
+
+func normalDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// The outcome of DMARC record discovery, see https://tools.ietf.org/html/rfc7489 section 6.6.3
+const (
+	DMARC_FOUND            = iota //0
+	DMARC_NO_POLICY               //1
+	DMARC_MULTIPLE_RECORDS        //2, treated as if there were no policy
+	DMARC_TEMP_ERROR              //3
+)
+
+var DMARCFindings = map[int]string{
+	DMARC_FOUND:            "found",
+	DMARC_NO_POLICY:        "no policy",
+	DMARC_MULTIPLE_RECORDS: "multiple records",
+	DMARC_TEMP_ERROR:       "temporary error",
+}
+
+var dmarcVersion = regexp.MustCompile(`^v[ \t]*=[ \t]*DMARC1[ \t]*(;|$)`)
+
+/*
+   Fetches and parses the DMARC record for domain. Only TXT records starting with v=DMARC1 count,
+   and when _dmarc.<domain> has none the organizational domain's are used instead.
+   The profile is always returned, with Discovery saying whether a single policy was found.
+*/
+func LookupDMARC(r Resolver, domain string) *DMARCProfile {
+	domain = normalDomain(domain)
+
+	names := []string{domain}
+	if org := OrgDomain(domain); org != domain {
+		names = append(names, org)
+	}
+
+	for _, d := range names {
+		txts, err := r.LookupTXT("_dmarc." + d)
+		if err != nil && !isNotFound(err) {
+			return &DMARCProfile{Domain: d, Discovery: DMARC_TEMP_ERROR}
+		}
+
+		var records []string
+		for _, txt := range txts {
+			if dmarcVersion.MatchString(txt) {
+				records = append(records, txt)
+			}
+		}
+
+		switch len(records) {
+		case 0:
+			continue
+		case 1:
+			p := ParseDMARC(records[0], d)
+			AuthorizeReports(r, p)
+			return p
+		default:
+			//a domain publishing more than one record has no policy at all, the org domain isn't consulted
+			return &DMARCProfile{Domain: d, Discovery: DMARC_MULTIPLE_RECORDS}
+		}
+	}
+
+	return &DMARCProfile{Domain: domain, Discovery: DMARC_NO_POLICY}
+}
+
+// A destination for aggregate (rua=) or failure (ruf=) reports, see https://tools.ietf.org/html/rfc7489 section 6.4
+type ReportURI struct {
+	Tag     string //"rua" or "ruf"
+	URI     string //e.g. mailto:dmarc@bank.com
+	Domain  string //of the mailbox, or the host for other schemes
+	MaxSize int64  //in bytes from a trailing "!10m", 0 if there's no limit
+
+	//the destination is within the policy's organizational domain, or has agreed to receive its reports
+	Authorized bool
+}
+
+/*
+   Parses a comma separated rua= or ruf= list, e.g. "mailto:dmarc@bank.com,mailto:dmarc@esp.com!10m".
+   The valid URIs are returned even when others are malformed.
+*/
+func ParseReportURIs(tag string, uris string) (reports []ReportURI, err error) {
+	for _, v := range strings.Split(uris, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		report, e := parseReportURI(v)
+		if e != nil {
+			err = e
+			continue
+		}
+		report.Tag = tag
+		reports = append(reports, report)
+	}
+	return
+}
+
+func parseReportURI(v string) (report ReportURI, err error) {
+	if i := strings.LastIndex(v, "!"); i != -1 {
+		if report.MaxSize, err = parseReportSize(v[i+1:]); err != nil {
+			return report, fmt.Errorf("%q: %s", v, err)
+		}
+		v = v[:i]
+	}
+
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme == "" {
+		return report, fmt.Errorf("%q is not a URI", v)
+	}
+	report.URI = v
+
+	if strings.EqualFold(u.Scheme, "mailto") {
+		addr := u.Opaque
+		if i := strings.Index(addr, "?"); i != -1 {
+			addr = addr[:i]
+		}
+		at := strings.LastIndex(addr, "@")
+		if at <= 0 || at == len(addr)-1 {
+			return report, fmt.Errorf("%q has no mailbox", v)
+		}
+		report.Domain = normalDomain(addr[at+1:])
+	} else {
+		report.Domain = normalDomain(u.Hostname())
+	}
+
+	if report.Domain == "" {
+		return report, fmt.Errorf("%q has no domain", v)
+	}
+	return
+}
+
+// A size is a number of bytes, optionally followed by k, m, g or t (powers of 2^10)
+func parseReportSize(size string) (int64, error) {
+	units := map[byte]uint{'k': 10, 'm': 20, 'g': 30, 't': 40}
+
+	shift := uint(0)
+	if size != "" {
+		if u, ok := units[strings.ToLower(size)[len(size)-1]]; ok {
+			shift = u
+			size = size[:len(size)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size limit %q", size)
+	}
+	return n << shift, nil
+}
+
+/*
+   Checks that every destination outside the policy's organizational domain has agreed to receive reports,
+   by publishing a v=DMARC1 record at <policy domain>._report._dmarc.<destination>.
+   See https://tools.ietf.org/html/rfc7489 section 7.1
+   Destinations which are already authorized aren't looked up again.
+*/
+func AuthorizeReports(r Resolver, p *DMARCProfile) {
+	authorized := make(map[string]bool)
+
+	for i, report := range p.Reports {
+		if report.Authorized {
+			continue
+		}
+
+		ok, seen := authorized[report.Domain]
+		if !seen {
+			txts, _ := r.LookupTXT(p.Domain + "._report._dmarc." + report.Domain)
+			for _, txt := range txts {
+				if dmarcVersion.MatchString(txt) {
+					ok = true
+				}
+			}
+			authorized[report.Domain] = ok
+		}
+		p.Reports[i].Authorized = ok
+	}
+}
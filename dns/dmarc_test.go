@@ -0,0 +1,150 @@
+package dns
+
+import (
+    "reflect"
+    "testing"
+)
+
+var EvaluateDMARCTests = []struct {
+    p      *DMARCProfile
+    from   string
+    spf    DMARCAuth
+    dkim   []DMARCAuth
+    sample int64
+
+    result DMARCResult
+}{
+    //relaxed alignment through the organizational domain
+    {p: &DMARCProfile{V:1, P:"reject", PCT:100, Domain:"bank.com"}, from: "bank.com",
+     spf: DMARCAuth{PASS, "bounces.bank.com"},
+     result: DMARCResult{PASS, true, false, "reject", "none", true}},
+    {p: &DMARCProfile{V:1, P:"reject", PCT:100, ASPF:"s", Domain:"bank.com"}, from: "bank.com",
+     spf: DMARCAuth{PASS, "bounces.bank.com"},
+     result: DMARCResult{FAIL, false, false, "reject", "reject", true}},
+    {p: &DMARCProfile{V:1, P:"reject", PCT:100, ADKIM:"s", Domain:"bank.com"}, from: "bank.com",
+     spf: DMARCAuth{FAIL, "bank.com"},
+     dkim: []DMARCAuth{{FAIL, "bank.com"}, {PASS, "bank.com"}},
+     result: DMARCResult{PASS, false, true, "reject", "none", true}},
+    //a passing but unaligned signature doesn't help
+    {p: &DMARCProfile{V:1, P:"quarantine", PCT:100, Domain:"bank.com"}, from: "bank.com",
+     spf: DMARCAuth{SOFT_FAIL, "bank.com"},
+     dkim: []DMARCAuth{{PASS, "esp.com"}},
+     result: DMARCResult{FAIL, false, false, "quarantine", "quarantine", true}},
+    //sp= applies to subdomains of the policy domain
+    {p: &DMARCProfile{V:1, P:"reject", SP:"none", PCT:100, Domain:"bank.com"}, from: "news.bank.com",
+     spf: DMARCAuth{FAIL, "bank.com"},
+     result: DMARCResult{FAIL, false, false, "none", "none", true}},
+    {p: &DMARCProfile{V:1, P:"reject", PCT:100, Domain:"bank.com"}, from: "news.bank.com",
+     spf: DMARCAuth{FAIL, "bank.com"},
+     result: DMARCResult{FAIL, false, false, "reject", "reject", true}},
+    //pct= sampling
+    {p: &DMARCProfile{V:1, P:"reject", PCT:20, Domain:"bank.com"}, from: "bank.com", sample: 19,
+     result: DMARCResult{FAIL, false, false, "reject", "reject", true}},
+    {p: &DMARCProfile{V:1, P:"reject", PCT:20, Domain:"bank.com"}, from: "bank.com", sample: 20,
+     result: DMARCResult{FAIL, false, false, "reject", "quarantine", false}},
+    {p: &DMARCProfile{V:1, P:"quarantine", PCT:0, Domain:"bank.com"}, from: "bank.com", sample: 0,
+     result: DMARCResult{FAIL, false, false, "quarantine", "none", false}},
+    {p: &DMARCProfile{V:1, P:"bogus", PCT:100, Domain:"bank.com"}, from: "bank.com",
+     result: DMARCResult{FAIL, false, false, "none", "none", true}},
+}
+
+func TestEvaluateDMARC(t *testing.T) {
+    defer func(f func() int64) { dmarcSample = f }(dmarcSample)
+
+    for _, tt := range EvaluateDMARCTests {
+        sample := tt.sample
+        dmarcSample = func() int64 { return sample }
+
+        if r := EvaluateDMARC(tt.p, tt.from, tt.spf, tt.dkim); !reflect.DeepEqual(*r, tt.result) {
+            t.Errorf("EvaluateDMARC for %s with %+v was %+v \n want %+v\n", tt.from, tt.p, *r, tt.result)
+        }
+    }
+}
+
+var LookupDMARCTests = []struct {
+    domain string
+
+    found     string
+    discovery int
+    policy    string
+}{
+    {domain: "bank.com", found: "bank.com", discovery: DMARC_FOUND, policy: "reject"},
+    {domain: "mail.bank.com", found: "bank.com", discovery: DMARC_FOUND, policy: "reject"},
+    {domain: "a.b.bank.co.uk", found: "bank.co.uk", discovery: DMARC_FOUND, policy: "quarantine"},
+    {domain: "news.bank.co.uk", found: "news.bank.co.uk", discovery: DMARC_FOUND, policy: "none"},
+    {domain: "explained.com", found: "explained.com", discovery: DMARC_FOUND, policy: "quarantine"},
+    {domain: "esp.com", found: "esp.com", discovery: DMARC_NO_POLICY},
+    {domain: "missing.com", found: "missing.com", discovery: DMARC_NO_POLICY},
+    {domain: "twice.com", found: "twice.com", discovery: DMARC_MULTIPLE_RECORDS},
+    //multiple records stop discovery rather than falling back to the organizational domain
+    {domain: "mail.twice.com", found: "mail.twice.com", discovery: DMARC_MULTIPLE_RECORDS},
+    {domain: "other.twice.com", found: "twice.com", discovery: DMARC_MULTIPLE_RECORDS},
+}
+
+func TestLookupDMARC(t *testing.T) {
+    for _, tt := range LookupDMARCTests {
+        p := LookupDMARC(testResolver, tt.domain)
+        if p.Domain != tt.found || p.Discovery != tt.discovery || p.P != tt.policy {
+            t.Errorf("LookupDMARC for %q found p=%q at %s (%s) \n want p=%q at %s (%s)\n", tt.domain,
+                     p.P, p.Domain, DMARCFindings[p.Discovery], tt.policy, tt.found, DMARCFindings[tt.discovery])
+        }
+        if p.Discovery != DMARC_FOUND {
+            if s := ScoreDMARC(testResolver, p); s != 0 {
+                t.Errorf("ScoreDMARC for %s with %s was %d\n", tt.domain, DMARCFindings[p.Discovery], s)
+            }
+            if r := EvaluateDMARC(p, tt.domain, DMARCAuth{}, nil); r.Result != NONE || r.Disposition != "none" {
+                t.Errorf("EvaluateDMARC for %s with %s was %+v\n", tt.domain, DMARCFindings[p.Discovery], *r)
+            }
+        }
+    }
+}
+
+var ParseReportURIsTests = []struct {
+    uris string
+
+    reports []ReportURI
+    err     bool
+}{
+    {uris: "mailto:dmarc@bank.com", reports: []ReportURI{{"rua", "mailto:dmarc@bank.com", "bank.com", 0, false}}},
+    {uris: "mailto:dmarc@Bank.com!10m, mailto:dmarc@esp.com!500",
+     reports: []ReportURI{{"rua", "mailto:dmarc@Bank.com", "bank.com", 10 << 20, false},
+                          {"rua", "mailto:dmarc@esp.com", "esp.com", 500, false}}},
+    {uris: "mailto:dmarc@bank.com!1g,https://reports.esp.com/dmarc",
+     reports: []ReportURI{{"rua", "mailto:dmarc@bank.com", "bank.com", 1 << 30, false},
+                          {"rua", "https://reports.esp.com/dmarc", "reports.esp.com", 0, false}}},
+    //the valid URIs are kept
+    {uris: "dmarc@bank.com,mailto:dmarc@esp.com", reports: []ReportURI{{"rua", "mailto:dmarc@esp.com", "esp.com", 0, false}}, err: true},
+    {uris: "mailto:dmarc@bank.com!10x", err: true},
+    {uris: "mailto:bank.com", err: true},
+    {uris: "", reports: nil},
+}
+
+func TestParseReportURIs(t *testing.T) {
+    for _, tt := range ParseReportURIsTests {
+        reports, err := ParseReportURIs("rua", tt.uris)
+        if !reflect.DeepEqual(reports, tt.reports) || (err != nil) != tt.err {
+            t.Errorf("ParseReportURIs for %q was %+v (%v) \n want %+v\n", tt.uris, reports, err, tt.reports)
+        }
+    }
+
+    p := ParseDMARC("v=DMARC1; p=reject; rua=mailto:dmarc@bank.com!big", "bank.com")
+    if len(p.Diagnostics) != 1 || p.Diagnostics[0].Tag != "rua" {
+        t.Errorf("ParseDMARC diagnostics for a malformed rua= were %v\n", p.Diagnostics)
+    }
+}
+
+func TestAuthorizeReports(t *testing.T) {
+    p := LookupDMARC(testResolver, "explained.com")
+    want := []ReportURI{{"rua", "mailto:reports@esp.com", "esp.com", 0, true},
+                        {"rua", "mailto:d@explained.com", "explained.com", 50 << 10, true},
+                        {"ruf", "mailto:f@esp.com", "esp.com", 0, true}}
+    if !reflect.DeepEqual(p.Reports, want) {
+        t.Errorf("Reports for explained.com were %+v \n want %+v\n", p.Reports, want)
+    }
+
+    //esp.com only authorized explained.com
+    p = LookupDMARC(testResolver, "news.bank.co.uk")
+    if len(p.Reports) != 1 || p.Reports[0].Authorized {
+        t.Errorf("Reports for news.bank.co.uk were %+v\n", p.Reports)
+    }
+}
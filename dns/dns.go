@@ -1,27 +1,28 @@
 package dns
 
 import (
-	b64 "encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"math"
 	"net"
-	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	//netaddr "github.com/ziutek/utils/netaddr"
 	reflections "github.com/oleiade/reflections"
 )
 
-//TODO: handle None, PermError, TempError?
 //For SPF
 const (
-	UNDEF     = iota //0
-	PASS             //1
-	FAIL             //2
-	SOFT_FAIL        //3
-	NEUTRAL          //4
+	UNDEF      = iota //0
+	PASS              //1
+	FAIL              //2
+	SOFT_FAIL         //3
+	NEUTRAL           //4
+	NONE              //5
+	PERM_ERROR        //6
+	TEMP_ERROR        //7
 )
 
 //For DMARC, might be redundant
@@ -46,6 +47,15 @@ type SPFProfile struct {
 	IP     string
 	Domain string
 	Record string
+
+	Redirect string
+	Children map[string]*SPFProfile //include: and redirect= targets, filled in by ResolveSPF
+	Lookups  int                    //DNS-querying terms, including those of Children
+	Result   int                    //NONE, PERM_ERROR or TEMP_ERROR if the record couldn't be resolved
+	Err      error
+
+	Diagnostics  []Diagnostic //malformed terms
+	LookupErrors []Diagnostic //a: and mx: terms whose addresses couldn't be looked up, CheckHost decides what they mean
 }
 
 //See https://tools.ietf.org/html/rfc6376
@@ -58,6 +68,9 @@ type DKIMDNSProfile struct {
 	P string
 	S string
 	T map[string]bool
+
+	Diagnostics  []Diagnostic //malformed terms
+	LookupErrors []Diagnostic //a: and mx: terms whose addresses couldn't be looked up, CheckHost decides what they mean
 }
 
 //See https://tools.ietf.org/html/rfc6376 section 3.5 for more details
@@ -117,14 +130,27 @@ type DMARCProfile struct {
 	*/
 	FO string
 
-	Domain string
+	Domain    string
+	Discovery int         //DMARC_FOUND unless LookupDMARC couldn't settle on a single record
+	Reports   []ReportURI //rua= then ruf=
+
+	Diagnostics []Diagnostic
 }
 
-func handleError(err error) {
-	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(1)
-	}
+// A malformed tag or term found while parsing a record, the rest of the record is still parsed
+type Diagnostic struct {
+	Pos int //byte offset of the tag within the record
+	Tag string
+	Err error
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s (at %d): %s", d.Tag, d.Pos, d.Err)
+}
+
+func sortDiagnostics(d []Diagnostic) []Diagnostic {
+	sort.SliceStable(d, func(i, j int) bool { return d[i].Pos < d[j].Pos })
+	return d
 }
 
 // Parses parameters of the format "xxx(=xxxx)?"
@@ -150,13 +176,36 @@ func parseParams(v string) (params map[string]string) {
 	return
 }
 
-func parseInt(str string) (r int64) {
-	r, err := strconv.ParseInt(str, 10, 64)
-	handleError(err)
+// Byte offset of the first occurrence of each tag parsed by parseParams, also used for DKIM-Signature tags
+func TagPositions(v string) (pos map[string]int) {
+	pos = make(map[string]int)
+	offset := 0
+
+	for _, s := range strings.Split(v, ";") {
+		trimmed := strings.TrimLeft(s, " \t\r\n")
+		key := strings.ToLower(strings.TrimSpace(strings.SplitN(trimmed, "=", 2)[0]))
+		if _, ok := pos[key]; !ok {
+			pos[key] = offset + len(s) - len(trimmed)
+		}
+		offset += len(s) + 1
+	}
 
 	return
 }
 
+func contains(arr []string, str string) bool {
+	for _, v := range arr {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+func parseInt(str string) (int64, error) {
+	return strconv.ParseInt(str, 10, 64)
+}
+
 // dmarc policy
 var dp = map[string]int{
 	"none":       0,
@@ -177,56 +226,83 @@ var dfo = map[string]int{
 	"1": 2,
 }
 
-func ScoreDMARC(p *DMARCProfile) int {
+/*
+   Every diagnostic found while parsing the record costs a point.
+   Report destinations outside the organizational domain are first checked with AuthorizeReports.
+*/
+func ScoreDMARC(r Resolver, p *DMARCProfile) int {
 
-	if p.V != 1 {
+	if p.Discovery != DMARC_FOUND || p.V != 1 {
 		return 0
 	}
 
-	if p.RUF != "" && !strings.HasSuffix(p.RUF, p.Domain) {
-		return 0
-	}
+	AuthorizeReports(r, p)
 
-	if p.RUA != "" && !strings.HasSuffix(p.RUA, p.Domain) {
-		return 0
+	//reports sent to a third party which hasn't agreed to receive them
+	for _, report := range p.Reports {
+		if !report.Authorized {
+			return 0
+		}
 	}
 
-	return int(p.PCT/100) *
+	score := int(p.PCT/100) *
 		(dp[p.P] +
 			dp[p.SP] +
 			da[p.ADKIM] +
 			da[p.ASPF] +
 			dfo[p.FO])
+
+	score -= len(p.Diagnostics)
+	if score < 0 {
+		return 0
+	}
+	return score
 }
 
-func ScoreDKIM(p_sig *DKIMSigProfile) int {
+// The error is set when the key couldn't be looked up or there isn't exactly one key record, in which case the score is 0
+func ScoreDKIM(r Resolver, p_sig *DKIMSigProfile) (int, error) {
 
 	if p_sig.S == "" || p_sig.D == "" {
 		//can't do DKIM lookup without these required fields
-		return 0
+		return 0, nil
 	}
 
-	txts, err := net.LookupTXT(p_sig.S + "._domainkey." + p_sig.D)
-	//TODO: better error handling
-	handleError(err)
+	txts, err := r.LookupTXT(p_sig.S + "._domainkey." + p_sig.D)
+	if err != nil {
+		return 0, err
+	}
 
-	p_dns := ParseDKIMDNS(txts[0])
+	record, err := DKIMKeyRecord(txts)
+	if err != nil {
+		return 0, fmt.Errorf("%s._domainkey.%s: %s", p_sig.S, p_sig.D, err)
+	}
+	p_dns := ParseDKIMDNS(record)
 
-	return scoreDKIMDNS(p_dns)
+	return scoreDKIMDNS(p_dns), nil
 }
 
+/*
+   Scores the key itself: 2 for RSA keys of at least 2048 bits and Ed25519 keys, 1 for 1024 bit RSA keys.
+   Revoked, broken and non-email keys score 0, and a key which can only be used with SHA-1 loses a point.
+*/
 func scoreDKIMDNS(p_dns *DKIMDNSProfile) int {
 
-	if p_dns.T["y"] {
+	if p_dns.T["y"] || len(p_dns.Diagnostics) > 0 {
 		//DKIM isn't active, since t=y denotes testing mode
+		//and a key record with malformed tags can't be trusted
 		return 0
 	}
 
-	sDec, _ := b64.StdEncoding.DecodeString(p_dns.P)
-
-	//TODO: What's the significance of this length?
-	return len(sDec)
+	key := ParseDKIMKey(p_dns)
+	if key.Strength == KEY_BROKEN || !key.ForEmail() {
+		return 0
+	}
 
+	score := key.Strength
+	if !key.AllowsHash("sha256") {
+		score -= 1
+	}
+	return score
 }
 
 // From: https://github.com/mindreframer/golang-stuff/blob/master/github.com/dotcloud/docker/network.go
@@ -266,7 +342,7 @@ func ScoreSPF(p *SPFProfile) (score float64) {
 
 	score = 0
 
-	if p.all == PASS {
+	if p.all == PASS || p.Result == PERM_ERROR {
 		return 0
 	}
 
@@ -275,15 +351,21 @@ func ScoreSPF(p *SPFProfile) (score float64) {
 		switch field {
 		case "IP4", "A", "MX":
 			f, err := reflections.GetField(p, field)
-			handleError(err)
+			if err != nil {
+				continue
+			}
 			arr, err := reflections.GetField(f, "Pass")
-			handleError(err)
+			if err != nil {
+				continue
+			}
 			score += scoreIPNets(arr.([]net.IPNet))
 		case "PTR", "EXISTS", "INCLUDE":
 			f, err := reflections.GetField(p, field)
-			handleError(err)
+			if err != nil {
+				continue
+			}
 			for addr, _ := range f.(map[string]int) {
-				if strings.HasSuffix(addr, p.Domain) {
+				if sameOrg(addr, p.Domain) {
 					score += 10
 				} else if addr != "" {
 					score -= 10
@@ -292,70 +374,123 @@ func ScoreSPF(p *SPFProfile) (score float64) {
 		}
 	}
 
-	//TODO: exists: handle macros
 	//TODO: include: lookup SPF record for domain, check if the current domain/ip controls this addr
 
+	//malformed terms are weighted like a foreign include
+	score -= 10 * float64(len(p.Diagnostics))
+
 	return
 }
 
-//TODO: may need to strip some chars at the start of domain, this func currently expects a second-level domain e.g. example.com
+// domain is where the record was found, which may be the organizational domain of the one asked about
 func ParseDMARC(record string, domain string) *DMARCProfile {
-	p := DMARCProfile{Domain: domain, PCT: 100, RF: "AFRF", RI: 86400, FO: "0"}
+	p := DMARCProfile{Domain: normalDomain(domain), PCT: 100, RF: "AFRF", RI: 86400, FO: "0"}
+	pos := TagPositions(record)
 
 	for key, value := range parseParams(record) {
 
+		var err error
 		switch key {
 		case "v":
 			value = strings.TrimLeft(value, "DMARC")
 			fallthrough
 		case "pct", "ri":
-			err := reflections.SetField(&p, strings.ToUpper(key), parseInt(value))
-			handleError(err)
+			var i int64
+			if i, err = parseInt(value); err == nil {
+				err = reflections.SetField(&p, strings.ToUpper(key), i)
+			}
 		case "ruf", "rua", "p", "sp", "adkim", "aspf", "rf", "fo":
 			//p.P = value
-			err := reflections.SetField(&p, strings.ToUpper(key), value)
-			handleError(err)
+			err = reflections.SetField(&p, strings.ToUpper(key), value)
 		default:
 			//undefined field
 		}
+
+		if err != nil {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{pos[key], key, err})
+		}
 	}
 
+	for _, tag := range []string{"rua", "ruf"} {
+		uris := p.RUA
+		if tag == "ruf" {
+			uris = p.RUF
+		}
+		reports, err := ParseReportURIs(tag, uris)
+		if err != nil {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{pos[tag], tag, err})
+		}
+		for _, report := range reports {
+			report.Authorized = sameOrg(report.Domain, p.Domain)
+			p.Reports = append(p.Reports, report)
+		}
+	}
+
+	p.Diagnostics = sortDiagnostics(p.Diagnostics)
 	return &p
 }
 
 func ParseDKIMDNS(record string) *DKIMDNSProfile {
 	p := DKIMDNSProfile{}
+	pos := TagPositions(record)
 
 	for key, value := range parseParams(record) {
+		var err error
 		switch key {
 		case "v":
-			v := strings.TrimLeft(value, "DKIM")
-			err := reflections.SetField(&p, strings.ToUpper(key), parseInt(v))
-			handleError(err)
+			var v int64
+			if v, err = parseInt(strings.TrimLeft(value, "DKIM")); err == nil {
+				err = reflections.SetField(&p, strings.ToUpper(key), v)
+			}
 		case "t":
 			m := make(map[string]bool)
 			for _, k := range strings.Split(value, ",") {
 				m[k] = true
 			}
-			err := reflections.SetField(&p, strings.ToUpper(key), m)
-			handleError(err)
+			err = reflections.SetField(&p, strings.ToUpper(key), m)
 		case "g", "h", "k", "n", "p", "s":
-			err := reflections.SetField(&p, strings.ToUpper(key), value)
-			handleError(err)
+			err = reflections.SetField(&p, strings.ToUpper(key), value)
 		}
 
+		if err != nil {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{pos[key], key, err})
+		}
 	}
+
+	p.Diagnostics = sortDiagnostics(p.Diagnostics)
 	return &p
 }
 
-func ParseSPF(record string, IP string) (p *SPFProfile) {
-	split := strings.Split(record, " ")
-	version := parseInt(strings.TrimPrefix(split[0], "v=spf"))
+func ParseSPF(r Resolver, record string, IP string) (p *SPFProfile) {
+	p = &SPFProfile{Record: record, IP: IP,
+		PTR:     make(map[string]int),
+		EXISTS:  make(map[string]int),
+		INCLUDE: make(map[string]int)}
+
+	split := strings.Fields(record)
+	if len(split) == 0 {
+		p.Diagnostics = []Diagnostic{{0, "v", fmt.Errorf("empty record")}}
+		return
+	}
 
-	p = &SPFProfile{version: version, Record: record, IP: IP}
+	version, err := parseInt(strings.TrimPrefix(split[0], "v=spf"))
+	if err != nil {
+		p.Diagnostics = append(p.Diagnostics, Diagnostic{0, split[0], err})
+	}
+	p.version = version
 
+	offset := len(split[0])
 	for _, mech := range split[1:] {
-		ParseMechanism(mech, p)
+		pos := offset + strings.Index(record[offset:], mech)
+		offset = pos + len(mech)
+
+		if err := ParseMechanism(r, mech, p); err != nil {
+			if _, ok := err.(lookupError); ok {
+				p.LookupErrors = append(p.LookupErrors, Diagnostic{pos, mech, err})
+			} else {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{pos, mech, err})
+			}
+		}
 	}
 
 	return
@@ -371,7 +506,7 @@ var ParseMechPrefixes = map[string]int{
 //TODO: Check for confusion in the record
 //TODO: Issue that the Parsed version will be static, whereas SPF records are more dynamic in nature
 //TODO: Probably refactor this
-func ParseMechanism(mech string, p *SPFProfile) {
+func ParseMechanism(r Resolver, mech string, p *SPFProfile) error {
 
 	prefix := ParseMechPrefixes[string(mech[0])]
 	if prefix == 0 {
@@ -383,73 +518,88 @@ func ParseMechanism(mech string, p *SPFProfile) {
 
 	if m == "all" {
 		p.all = int64(prefix)
+		return nil
+	}
+
+	if strings.HasPrefix(m, "redirect=") {
+		p.Redirect = strings.TrimPrefix(m, "redirect=")
+		return nil
 	}
 
 	split := strings.SplitN(m, ":", 2)
+	if len(split) == 1 {
+		for _, name := range []string{"ip4", "ip6", "exists", "include"} {
+			if strings.HasPrefix(split[0], name) {
+				return fmt.Errorf("%s requires an argument", name)
+			}
+		}
+	}
 
 	//Use of boolean switch makes for more boilerplate, but required for correctness
 	switch {
 	case strings.HasPrefix(split[0], "ip4"):
-		ParseIPRange(split[1], prefix, &p.IP4)
+		return ParseIPRange(split[1], prefix, &p.IP4)
 	case strings.HasPrefix(split[0], "ip6"):
-		ParseIPRange(split[1], prefix, &p.IP6)
+		return ParseIPRange(split[1], prefix, &p.IP6)
 	case strings.HasPrefix(split[0], "a"):
 		if len(split) == 1 {
-			ParseIPRange(strings.Replace(split[0], "a", p.IP, 1),
+			return ParseIPRange(strings.Replace(split[0], "a", p.IP, 1),
 				prefix,
 				&p.A)
 		} else {
-			ParseDomain(split[1], prefix, p.A)
+			return ParseDomain(r, split[1], prefix, p.A)
 		}
 	case strings.HasPrefix(split[0], "mx"):
 		if len(split) == 1 {
-			ParseIPRange(strings.Replace(split[0], "mx", p.IP, 1),
+			return ParseIPRange(strings.Replace(split[0], "mx", p.IP, 1),
 				prefix,
 				&p.MX)
 		} else {
-			ParseDomain(split[1], prefix, p.MX)
+			return ParseDomain(r, split[1], prefix, p.MX)
 		}
 	case strings.HasPrefix(split[0], "ptr"):
-		var ptr []string
-		var err error
-
-		if len(split) == 1 {
-			//ptr
-			ptr, err = net.LookupAddr(p.Domain)
-
-		} else {
-			//ptr:<domain>
-			ptr, err = net.LookupAddr(split[1])
-		}
-
-		handleError(err)
-		for _, addr := range ptr {
-			(*p).PTR[addr] = prefix
+		//ptr:<domain>, or the record's own domain when stored as "", only CheckHost can look up the client's names
+		target := ""
+		if len(split) == 2 {
+			target = split[1]
 		}
+		(*p).PTR[target] = prefix
 	case strings.HasPrefix(split[0], "exists"):
-		//exists:<domain>
-		//TODO: handle macros
+		//exists:<domain>, macros are stored unexpanded as they depend on the sender, see CheckHost
 		(*p).EXISTS[split[1]] = prefix
 	case strings.HasPrefix(split[0], "include"):
-		//include:<domain>
-		//TODO: lookup SPF record for domain
+		//include:<domain>, the record itself is followed into Children by ResolveSPF
 		(*p).INCLUDE[split[1]] = prefix
 	}
+
+	return nil
 }
 
-func ParseDomain(domain string, prefix int, ipr IPRange) {
+func ParseDomain(r Resolver, domain string, prefix int, ipr IPRange) error {
 	split := strings.SplitN(domain, "/", 2)
 
-	hosts, err := net.LookupHost(split[0])
-	handleError(err)
+	hosts, err := r.LookupIP("ip", split[0])
+	if err != nil {
+		return lookupError{err}
+	}
 
 	for _, host := range hosts {
 		if len(split) == 1 {
-			ParseIPRange(host, prefix, &ipr)
+			err = ParseIPRange(host.String(), prefix, &ipr)
 		} else {
-			ParseIPRange(host+"/"+split[1], prefix, &ipr)
+			err = ParseIPRange(host.String()+"/"+split[1], prefix, &ipr)
+		}
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
+}
+
+// A failed DNS lookup while parsing, which says nothing about whether the record is well formed
+type lookupError struct {
+	error
 }
 
 type IPRange struct {
@@ -460,7 +610,7 @@ type IPRange struct {
 }
 
 //TODO: get rid of the switches
-func ParseIPRange(ipexpr string, prefix int, ipr *IPRange) {
+func ParseIPRange(ipexpr string, prefix int, ipr *IPRange) error {
 	if !strings.Contains(ipexpr, "/") {
 		if strings.Contains(ipexpr, ":") {
 			ipexpr = ipexpr + "/128"
@@ -482,10 +632,10 @@ func ParseIPRange(ipexpr string, prefix int, ipr *IPRange) {
 		arr = ipr.Neutral
 	}
 
-	fmt.Printf("%+v", arr)
-
 	_, net, err := net.ParseCIDR(ipexpr)
-	handleError(err)
+	if err != nil {
+		return err
+	}
 
 	arr = append(arr, *net)
 
@@ -500,4 +650,5 @@ func ParseIPRange(ipexpr string, prefix int, ipr *IPRange) {
 		ipr.Neutral = arr
 	}
 
+	return nil
 }
@@ -104,6 +104,15 @@ var ScoreSPFTests = []struct {
     {p: &SPFProfile{Domain: "google.com", INCLUDE: map[string]int{"example.com":PASS}},
      score: -10,
     },
+    {p: &SPFProfile{Domain: "google.com", INCLUDE: map[string]int{"_spf.google.com":PASS}},
+     score: 10,
+    },
+    {p: &SPFProfile{Domain: "google.com", INCLUDE: map[string]int{"notgoogle.com":PASS}},
+     score: -10,
+    },
+    {p: &SPFProfile{Domain: "bank.co.uk", INCLUDE: map[string]int{"esp.co.uk":PASS}},
+     score: -10,
+    },
     //Combos:
     {p: &SPFProfile{Domain: "google.com", PTR: map[string]int{"example.com":PASS},
                     IP4: IPRange{Pass: []net.IPNet{{IP: []byte{192, 168, 0, 1},
@@ -131,14 +140,13 @@ func TestScoreSPF(t *testing.T) {
 }
 
 
-//TODO: want these tests to have results like 1024 and 2048, actual public key lengths
 var ScoreDKIMDNSTests = []struct {
     p *DKIMDNSProfile
 
     score int
 }{
     {p: &DKIMDNSProfile{V:1, P:"MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDoclZ4XpNdyh8fvXDbHKcVvp63pZX42ceid9R2FHT/UnG4dAtVcBWxzgxdThgSFYevgDkKC40Z1Kfj4kMeJxjdAl11Cbe//iArMdM3bmTJNP3FzFA82gwhE36jUGYawqWF1LcJXb0QH+khp/tRehMnFI4pRmvGGW/51yaazeBX6wIDAQAB"},
-     score:162,
+     score:1,
     },
     {p: &DKIMDNSProfile{V:1, T:map[string]bool{"y":true}, P:"MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDoclZ4XpNdyh8fvXDbHKcVvp63pZX42ceid9R2FHT/UnG4dAtVcBWxzgxdThgSFYevgDkKC40Z1Kfj4kMeJxjdAl11Cbe//iArMdM3bmTJNP3FzFA82gwhE36jUGYawqWF1LcJXb0QH+khp/tRehMnFI4pRmvGGW/51yaazeBX6wIDAQAB"},
      score:0,
@@ -146,8 +154,36 @@ var ScoreDKIMDNSTests = []struct {
     {p: &DKIMDNSProfile{V:1, P:"MIG"},
      score:0,
     },
+    {p: &DKIMDNSProfile{V:1, P:testKey2048},
+     score:2,
+    },
+    {p: &DKIMDNSProfile{V:1, K:"ed25519", P:"11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+     score:2,
+    },
+    {p: &DKIMDNSProfile{V:1, P:testKey512},
+     score:0,
+    },
+    //revoked
+    {p: &DKIMDNSProfile{V:1, K:"rsa"},
+     score:0,
+    },
+    {p: &DKIMDNSProfile{V:1, H:"sha1", P:testKey2048},
+     score:1,
+    },
+    {p: &DKIMDNSProfile{V:1, H:"sha1:sha256", P:testKey2048},
+     score:2,
+    },
+    {p: &DKIMDNSProfile{V:1, S:"tlsrpt", P:testKey2048},
+     score:0,
+    },
+    {p: &DKIMDNSProfile{V:1, S:"email:tlsrpt", P:testKey2048},
+     score:2,
+    },
 }
 
+const testKey512 = "MFwwDQYJKoZIhvcNAQEBBQADSwAwSAJBAKr9pOhYKg+BGXVUXk7GXNgpRbrXgYb9myJTOi7p+hFghxAco+UFC1LOA5VOYI1KmhToe0ABZ9mnmEfYsL9ej/0CAwEAAQ=="
+const testKey2048 = "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvJggrH37QyGzlWOul/gI1llO3RGioCBeTolLs3WX+DhIR8+OHa0g7GzSYu48/ipXLO6I75AujbewaDn6hHMVpfKKUJKs9g28VVzQHWIQDB97N5XMk8V7A1yAlkzMbTICXPBbxk8bwgX601jtSiF5FDAqMTBPNzuMYOtQtFn+3syJErtg7oSgUDRXeHZrWTqsNLRAe6L+Hp9vzZrA69cJ7GQEi0+FyGYL/iBeK3xJW+fcMYK6Z3+1upSzL0KAyGJBEIHzUh31GWjeXVYCJNJhB0garr2MQR2xw8NtdlfdKYnmVhPYkyal39qUvPZAhfTH+52pTNMNkYNC7Hehrq6ZewIDAQAB"
+
 func TestScoreDKIMDNS(t *testing.T) {
     for _, tt := range ScoreDKIMDNSTests {       
 
@@ -158,7 +194,7 @@ func TestScoreDKIMDNS(t *testing.T) {
 }
 
 
-//WARNING: these tests require a lookup, they might break at a moment's notice for things out of our control
+//The keys are looked up in testResolver
 var ScoreDKIMTests = []struct {
     p *DKIMSigProfile
 
@@ -173,7 +209,7 @@ var ScoreDKIMTests = []struct {
                           BH:"Vq/vP4CFQA5eYLaSAGG07LEMQiHvUQ53z2M5UvFlf3Q=",
                           B:`ooEK+zWITEXLRmoX6PX5sajrb4EkE4/tPYI5Afyeh6xrBfPshsCBCQ5TlkbZgrgq52gcM6SJq16IivSb2AA2IWY1Dr64xeP/MerZOpr2ZVrQh+fKNp9u3920oZtXbRlXtjIf8b5ZE3pwSFjZjzs/s+77EEUJR9L0jk7oigk0mG0=`},
 
-     score: 162,
+     score: 1,
     },
     // {p: &DKIMSigProfile{V:1, D:"service.yoursantander.co.uk", S:"default"},
     //  score:0,
@@ -185,7 +221,7 @@ var ScoreDKIMTests = []struct {
                            S:"s1024-2013-q3", T:1464936932, BH:"0/SqN7q7PTfU5P2gbZVqBbFiMaMYEiQoGv3hXqkNeAI=",
                            H:[]string{"Date", "To", "Subject", "From", "MIME-Version", "Content-Type"},
                            B: `KrZF1OsprlblWjX1lBg7rhIZ444gb1/yUjF7vEfJ9YieFKVyjAoNOzCWCiRzFcaGP4o2u4QarTQOQ3SmBXse40DbyPaYjak0ZY5TtEjatx1XyuvQreVEXn47BH4ZFxLLHxm5cSG077uuCDCRP9eBwHNRRXLQVi5MLmLpRYu2H4o=`},
-     score: 162,
+     score: 1,
     },
 }
 
@@ -193,7 +229,7 @@ func TestScoreDKIM(t *testing.T) {
 
     for _, tt := range ScoreDKIMTests {       
 
-        if s := ScoreDKIM(tt.p); !reflect.DeepEqual(tt.score, s) {
+        if s, _ := ScoreDKIM(testResolver, tt.p); !reflect.DeepEqual(tt.score, s) {
             t.Errorf("ScoreDKIM was %i \n want %i\n", s, tt.score)
         }
     }    
@@ -209,6 +245,26 @@ var ScoreDMARCTests = []struct {
     {p: &DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:postmaster@dmarcdomain.com", Domain: "dmarcdomain.com"},
      score: 2,
     },
+    {p: ParseDMARC("v=DMARC1; p=reject; rua=mailto:postmaster@notdmarcdomain.com", "dmarcdomain.com"),
+     score: 0,
+    },
+    {p: ParseDMARC("v=DMARC1; p=reject; rua=mailto:postmaster@reports.bank.co.uk!10m", "bank.co.uk"),
+     score: 2,
+    },
+    {p: ParseDMARC("v=DMARC1; p=reject; rua=mailto:postmaster@bank.co.uk,mailto:dmarc@co.uk", "bank.co.uk"),
+     score: 0,
+    },
+    //a vendor which has authorized the reports is scored like the domain itself
+    {p: LookupDMARC(testResolver, "explained.com"),
+     score: 1,
+    },
+    //even when the record wasn't found by LookupDMARC
+    {p: ParseDMARC("v=DMARC1; p=quarantine; rua=mailto:reports@esp.com", "explained.com"),
+     score: 1,
+    },
+    {p: LookupDMARC(testResolver, "news.bank.co.uk"),
+     score: 0,
+    },
 }
 
 
@@ -216,7 +272,7 @@ func TestScoreDMARC(t *testing.T) {
 
     for _, tt := range ScoreDMARCTests {       
 
-        if s := ScoreDMARC(tt.p); !reflect.DeepEqual(tt.score, s) {
+        if s := ScoreDMARC(testResolver, tt.p); !reflect.DeepEqual(tt.score, s) {
             t.Errorf("ScoreDMARC was %i \n want %i\n", s, tt.score)
         }
     }    
@@ -266,19 +322,23 @@ var ParseDMARCTests = []struct {
 }{
     {record: "v=DMARC1;p=reject;pct=100;rua=mailto:postmaster@dmarcdomain.com",
     domain: "dmarcdomain.com",
-    result: DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:postmaster@dmarcdomain.com", RF:"AFRF", RI:86400, FO:"0", Domain: "dmarcdomain.com"},
+    result: DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:postmaster@dmarcdomain.com", RF:"AFRF", RI:86400, FO:"0", Domain: "dmarcdomain.com",
+                          Reports: []ReportURI{{"rua", "mailto:postmaster@dmarcdomain.com", "dmarcdomain.com", 0, true}}},
     },
     {record: "v=DMARC1;      p=reject;pct=100;     rua=mailto:postmaster@dmarcdomain.com",
     domain: "dmarcdomain.com",
-    result: DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:postmaster@dmarcdomain.com", RF:"AFRF", RI:86400, FO:"0", Domain: "dmarcdomain.com"},
+    result: DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:postmaster@dmarcdomain.com", RF:"AFRF", RI:86400, FO:"0", Domain: "dmarcdomain.com",
+                          Reports: []ReportURI{{"rua", "mailto:postmaster@dmarcdomain.com", "dmarcdomain.com", 0, true}}},
     }, //handle whitespace ^^
     {record: "v=DMARC1; p=reject; rua=mailto:mailauth-reports@google.com",
     domain: "google.com",
-    result: DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:mailauth-reports@google.com", RF:"AFRF", RI:86400, FO:"0", Domain: "google.com"},
+    result: DMARCProfile{V:1, P: "reject", PCT:100, RUA:"mailto:mailauth-reports@google.com", RF:"AFRF", RI:86400, FO:"0", Domain: "google.com",
+                          Reports: []ReportURI{{"rua", "mailto:mailauth-reports@google.com", "google.com", 0, true}}},
     },
     {record: "v=DMARC1; p=none; rua=mailto:dmarc@github.com",
     domain: "github.com",
-    result: DMARCProfile{V:1, P: "none", PCT:100, RUA:"mailto:dmarc@github.com", RF:"AFRF", RI:86400, FO:"0", Domain: "github.com"},
+    result: DMARCProfile{V:1, P: "none", PCT:100, RUA:"mailto:dmarc@github.com", RF:"AFRF", RI:86400, FO:"0", Domain: "github.com",
+                          Reports: []ReportURI{{"rua", "mailto:dmarc@github.com", "github.com", 0, true}}},
     },
 }
 
@@ -377,7 +437,7 @@ func TestParseMechanism(t *testing.T) {
             case "A"  : p.A = IPRange{}
         }        
 
-        if ParseMechanism(tt.mech, &p); !reflect.DeepEqual(tt.result, p) {
+        if ParseMechanism(testResolver, tt.mech, &p); !reflect.DeepEqual(tt.result, p) {
             t.Errorf("ParseMechanism was %q \n want %q\n", p, tt.result)
         }
     }
@@ -438,3 +498,70 @@ func TestParseIPRange(t *testing.T) {
         }
     } 
 }
+
+//Malformed tags become diagnostics rather than stopping the process
+var ParseDiagnosticsTests = []struct {
+    record string
+
+    pos []int
+    score int
+}{
+    {record: "v=DMARC1; p=reject; pct=abc",
+     pos: []int{20},
+     score: 1,
+    },
+    {record: "v=DMARC1; p=reject; pct=abc; ri=1d",
+     pos: []int{20, 29},
+     score: 0,
+    },
+    {record: "v=DMARCx; p=reject",
+     pos: []int{0},
+     score: 0,
+    },
+}
+
+func TestParseDMARCDiagnostics(t *testing.T) {
+    for _, tt := range ParseDiagnosticsTests {
+        p := ParseDMARC(tt.record, "bank.com")
+
+        var pos []int
+        for _, d := range p.Diagnostics {
+            pos = append(pos, d.Pos)
+        }
+        if !reflect.DeepEqual(tt.pos, pos) {
+            t.Errorf("ParseDMARC diagnostics for %q were at %v\n want %v\n", tt.record, pos, tt.pos)
+        }
+        if s := ScoreDMARC(testResolver, p); s != tt.score {
+            t.Errorf("ScoreDMARC for %q was %d\n want %d\n", tt.record, s, tt.score)
+        }
+    }
+}
+
+func TestParseSPFDiagnostics(t *testing.T) {
+    p := ParseSPF(testResolver, "v=spf1  ip4:192.168.0.1/33 ip4 include:_spf.esp.com -all", "192.0.2.1")
+
+    if len(p.Diagnostics) != 2 || p.Diagnostics[0].Pos != 8 || p.Diagnostics[1].Pos != 27 {
+        t.Errorf("ParseSPF diagnostics were %v\n", p.Diagnostics)
+    }
+    if p.INCLUDE["_spf.esp.com"] != PASS {
+        t.Errorf("ParseSPF stopped at the first malformed term\n")
+    }
+
+    //failed lookups aren't malformed terms, and ptr is left to CheckHost
+    p = ParseSPF(testResolver, "v=spf1 ptr a:a.nonexistent.com -all", "192.0.2.1")
+    if len(p.Diagnostics) != 0 || len(p.LookupErrors) != 1 || p.LookupErrors[0].Pos != 11 || p.PTR[""] != PASS {
+        t.Errorf("ParseSPF diagnostics were %v and lookup errors %v\n", p.Diagnostics, p.LookupErrors)
+    }
+    if s := ScoreSPF(p); s < 0 {
+        t.Errorf("ScoreSPF for %q was %f\n", p.Record, s)
+    }
+}
+
+func TestScoreDKIMError(t *testing.T) {
+    if s, err := ScoreDKIM(testResolver, &DKIMSigProfile{V: 1, D: "bank.com", S: "missing"}); s != 0 || err == nil {
+        t.Errorf("ScoreDKIM for a missing key was %d (%v)\n want 0 and an error\n", s, err)
+    }
+    if s, err := ScoreDKIM(testResolver, &DKIMSigProfile{V: 1, D: "bank.com", S: "twice"}); s != 0 || err == nil {
+        t.Errorf("ScoreDKIM for two key records was %d (%v)\n want 0 and an error\n", s, err)
+    }
+}
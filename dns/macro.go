@@ -0,0 +1,232 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const spfDelimiters = ".-+,/_="
+
+/*
+   Expands an SPF macro-string, see https://tools.ietf.org/html/rfc7208 section 7
+   %{s} sender               %{l} local-part of sender   %{o} domain of sender
+   %{d} current domain       %{i} IP address             %{p} validated domain name of IP (deprecated)
+   %{v} "in-addr" or "ip6"   %{h} HELO domain
+   and, only in explanation strings (exp is true):
+   %{c} readable IP address  %{r} receiving domain       %{t} current timestamp
+
+   Each macro may be followed by a number of parts to keep, "r" to reverse and a set of delimiters,
+   e.g. %{ir}, %{d2}, %{l-}. An uppercase letter URL-escapes the result.
+*/
+func (c *spfCheck) expand(s string, domain string, exp bool) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("%q ends with a lone %%", s)
+		}
+
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated macro in %q", s)
+			}
+			v, err := c.expandMacro(s[i+1:i+end], domain, exp)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += end
+		default:
+			return "", fmt.Errorf("invalid macro %%%c in %q", s[i], s)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Expands the inside of a single %{...}
+func (c *spfCheck) expandMacro(macro string, domain string, exp bool) (string, error) {
+	if macro == "" {
+		return "", fmt.Errorf("empty macro")
+	}
+
+	letter := macro[0]
+	escape := letter >= 'A' && letter <= 'Z'
+	value, err := c.macroValue(strings.ToLower(string(letter)), domain, exp)
+	if err != nil {
+		return "", err
+	}
+
+	//transformers: *DIGIT ["r"], then any delimiters
+	rest := macro[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", fmt.Errorf("%%{%s} keeps zero parts", macro)
+		}
+	}
+	rest = rest[digits:]
+
+	reverse := strings.HasPrefix(rest, "r") || strings.HasPrefix(rest, "R")
+	if reverse {
+		rest = rest[1:]
+	}
+
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, spfDelimiters) != "" {
+			return "", fmt.Errorf("invalid delimiters in %%{%s}", macro)
+		}
+		delimiters = rest
+	}
+
+	if keep > 0 || reverse || delimiters != "." {
+		//empty parts count, so "a..b" is three parts
+		parts := splitAny(value, delimiters)
+		if reverse {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+	}
+
+	if escape {
+		value = urlEscape(value)
+	}
+	return value, nil
+}
+
+// Like strings.Split, but on any of the delimiters
+func splitAny(s string, delimiters string) (parts []string) {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(delimiters, s[i]) >= 0 {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func (c *spfCheck) macroValue(letter string, domain string, exp bool) (string, error) {
+	at := strings.LastIndex(c.sender, "@")
+
+	switch letter {
+	case "s":
+		return c.sender, nil
+	case "l":
+		return c.sender[:at], nil
+	case "o":
+		return c.sender[at+1:], nil
+	case "d":
+		return domain, nil
+	case "i":
+		return ipMacro(c.ip), nil
+	case "p":
+		return c.validatedName(domain), nil
+	case "v":
+		if c.ip.To4() != nil {
+			return "in-addr", nil
+		}
+		return "ip6", nil
+	case "h":
+		return c.helo, nil
+	}
+
+	if exp {
+		switch letter {
+		case "c":
+			return c.ip.String(), nil
+		case "r":
+			return "unknown", nil
+		case "t":
+			return strconv.FormatInt(time.Now().Unix(), 10), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid macro letter %q", letter)
+}
+
+// IPv4 addresses are dotted quads, IPv6 addresses are dot-separated nibbles
+func ipMacro(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0xf), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// %{p}: a reverse name of the IP which resolves back to it, preferring one within domain
+func (c *spfCheck) validatedName(domain string) string {
+	names, err := c.r.LookupAddr(c.ip.String())
+	if err != nil {
+		return "unknown"
+	}
+
+	validated := "unknown"
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		ips, err := c.r.LookupIP("ip", name)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if !ip.Equal(c.ip) {
+				continue
+			}
+			if strings.EqualFold(name, domain) || strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(domain)) {
+				return name
+			}
+			if validated == "unknown" {
+				validated = name
+			}
+		}
+	}
+	return validated
+}
+
+// Escapes everything but the unreserved characters of https://tools.ietf.org/html/rfc3986
+func urlEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '.', ch == '_', ch == '~':
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
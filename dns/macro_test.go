@@ -0,0 +1,78 @@
+package dns
+
+import (
+    "net"
+    "testing"
+)
+
+//Examples from https://tools.ietf.org/html/rfc7208 section 7.4
+var ExpandTests = []struct {
+    macro string
+    ip string
+
+    result string
+}{
+    {macro: "%{s}", ip: "192.0.2.3", result: "strong-bad@email.example.com"},
+    {macro: "%{o}", ip: "192.0.2.3", result: "email.example.com"},
+    {macro: "%{d}", ip: "192.0.2.3", result: "email.example.com"},
+    {macro: "%{d4}", ip: "192.0.2.3", result: "email.example.com"},
+    {macro: "%{d3}", ip: "192.0.2.3", result: "email.example.com"},
+    {macro: "%{d2}", ip: "192.0.2.3", result: "example.com"},
+    {macro: "%{d1}", ip: "192.0.2.3", result: "com"},
+    {macro: "%{dr}", ip: "192.0.2.3", result: "com.example.email"},
+    {macro: "%{d2r}", ip: "192.0.2.3", result: "example.email"},
+    {macro: "%{l}", ip: "192.0.2.3", result: "strong-bad"},
+    {macro: "%{l-}", ip: "192.0.2.3", result: "strong.bad"},
+    {macro: "%{lr}", ip: "192.0.2.3", result: "strong-bad"},
+    {macro: "%{lr-}", ip: "192.0.2.3", result: "bad.strong"},
+    {macro: "%{l1r-}", ip: "192.0.2.3", result: "strong"},
+    {macro: "%{ir}.%{v}._spf.%{d2}", ip: "192.0.2.3", result: "3.2.0.192.in-addr._spf.example.com"},
+    {macro: "%{lr-}.lp._spf.%{d2}", ip: "192.0.2.3", result: "bad.strong.lp._spf.example.com"},
+    {macro: "%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", ip: "192.0.2.3", result: "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+    {macro: "%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", ip: "192.0.2.3", result: "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+    {macro: "%{d2}.trusted-domains.example.net", ip: "192.0.2.3", result: "example.com.trusted-domains.example.net"},
+    {macro: "%{ir}.%{v}._spf.%{d2}", ip: "2001:db8::cb01", result: "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"},
+    {macro: "100%%%_%-%{S}", ip: "192.0.2.3", result: "100% %20strong-bad%40email.example.com"},
+}
+
+func TestExpand(t *testing.T) {
+    for _, tt := range ExpandTests {
+        c := newSPFCheck(testResolver, net.ParseIP(tt.ip), "strong-bad@email.example.com", "")
+
+        if r, err := c.expand(tt.macro, "email.example.com", false); r != tt.result {
+            t.Errorf("expand for %q was %q (%v)\n want %q\n", tt.macro, r, err, tt.result)
+        }
+    }
+
+    //empty parts are kept when splitting, see https://tools.ietf.org/html/rfc7208 section 7.3
+    c := newSPFCheck(testResolver, net.ParseIP("192.0.2.3"), "a..b@email.example.com", "")
+    for macro, result := range map[string]string{"%{l2}": ".b", "%{lr}": "b..a", "%{l3r}": "b..a", "%{l1r}": "a"} {
+        if r, err := c.expand(macro, "email.example.com", false); r != result {
+            t.Errorf("expand for %q was %q (%v)\n want %q\n", macro, r, err, result)
+        }
+    }
+
+    c = newSPFCheck(testResolver, net.ParseIP("192.0.2.3"), "strong-bad@email.example.com", "mx.example.org.")
+    if r, err := c.expand("%{h}", "email.example.com", false); r != "mx.example.org" {
+        t.Errorf("expand for %%{h} was %q (%v)\n want %q\n", r, err, "mx.example.org")
+    }
+}
+
+var ExpandErrorTests = []string{
+    "%{x}",
+    "%{c}",
+    "%{d0}",
+    "%{d",
+    "%a",
+    "trailing%",
+}
+
+func TestExpandError(t *testing.T) {
+    for _, macro := range ExpandErrorTests {
+        c := newSPFCheck(testResolver, net.ParseIP("192.0.2.3"), "strong-bad@email.example.com", "")
+
+        if r, err := c.expand(macro, "email.example.com", false); err == nil {
+            t.Errorf("expand for %q was %q\n want an error\n", macro, r)
+        }
+    }
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+)
+
+/*
+   A snapshot of https://publicsuffix.org/list/public_suffix_list.dat, used to find organizational domains.
+   Both the ICANN and private sections are used, so e.g. github.io sites are each their own organization.
+*/
+//go:embed public_suffix_list.dat
+var publicSuffixList string
+
+type suffixRules struct {
+	rules      map[string]bool //e.g. "co.uk"
+	wildcards  map[string]bool //"*.ck" is stored as "ck"
+	exceptions map[string]bool //"!www.ck" is stored as "www.ck"
+}
+
+var (
+	pslOnce sync.Once
+	psl     *suffixRules
+)
+
+func publicSuffixes() *suffixRules {
+	pslOnce.Do(func() {
+		psl = parsePSL(strings.NewReader(publicSuffixList))
+	})
+	return psl
+}
+
+// See https://github.com/publicsuffix/list/wiki/Format
+func parsePSL(r io.Reader) *suffixRules {
+	s := &suffixRules{make(map[string]bool), make(map[string]bool), make(map[string]bool)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		rule := fields[0]
+
+		//the list is in unicode, but domains are looked up in their ASCII form
+		if ascii, err := idna.ToASCII(strings.TrimLeft(rule, "!*.")); err == nil {
+			rule = rule[:len(rule)-len(strings.TrimLeft(rule, "!*."))] + ascii
+		}
+		rule = strings.ToLower(rule)
+
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			s.exceptions[rule[1:]] = true
+		case strings.HasPrefix(rule, "*."):
+			s.wildcards[rule[2:]] = true
+		default:
+			s.rules[rule] = true
+		}
+	}
+
+	return s
+}
+
+// The number of labels at the end of domain which form its public suffix, at least 1
+func (s *suffixRules) suffixLabels(labels []string) int {
+	for i := range labels {
+		candidate := strings.Join(labels[i:], ".")
+		switch {
+		case s.exceptions[candidate]:
+			return len(labels) - i - 1
+		case s.rules[candidate]:
+			return len(labels) - i
+		case i+1 < len(labels) && s.wildcards[strings.Join(labels[i+1:], ".")]:
+			return len(labels) - i
+		}
+	}
+
+	//the implicit rule "*"
+	return 1
+}
+
+// e.g. "co.uk" for "www.bank.co.uk"
+func PublicSuffix(domain string) string {
+	labels := strings.Split(normalDomain(domain), ".")
+	return strings.Join(labels[len(labels)-publicSuffixes().suffixLabels(labels):], ".")
+}
+
+/*
+   The organizational domain of https://tools.ietf.org/html/rfc7489 section 3.2, the public suffix plus one label,
+   e.g. "bank.co.uk" for "www.bank.co.uk". A domain which is itself a public suffix is returned unchanged.
+*/
+func OrgDomain(domain string) string {
+	labels := strings.Split(normalDomain(domain), ".")
+	n := publicSuffixes().suffixLabels(labels) + 1
+	if n > len(labels) {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// Whether both domains belong to the same organizational domain
+func sameOrg(a string, b string) bool {
+	a, b = normalDomain(a), normalDomain(b)
+	return a != "" && b != "" && OrgDomain(a) == OrgDomain(b)
+}
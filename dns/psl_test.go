@@ -0,0 +1,41 @@
+package dns
+
+import (
+    "testing"
+)
+
+var OrgDomainTests = []struct {
+    domain string
+
+    suffix string
+    org    string
+}{
+    {domain: "bank.com", suffix: "com", org: "bank.com"},
+    {domain: "www.Bank.COM.", suffix: "com", org: "bank.com"},
+    {domain: "online.bank.co.uk", suffix: "co.uk", org: "bank.co.uk"},
+    {domain: "co.uk", suffix: "co.uk", org: "co.uk"},
+    {domain: "user.github.io", suffix: "github.io", org: "user.github.io"},
+    //wildcard and exception rules
+    {domain: "a.b.example.ck", suffix: "example.ck", org: "b.example.ck"},
+    {domain: "www.ck", suffix: "ck", org: "www.ck"},
+    //internationalised suffixes are matched in their ASCII form
+    {domain: "bank.xn--p1ai", suffix: "xn--p1ai", org: "bank.xn--p1ai"},
+    //the implicit "*" rule
+    {domain: "host.bank.unknowntld", suffix: "unknowntld", org: "bank.unknowntld"},
+}
+
+func TestOrgDomain(t *testing.T) {
+    for _, tt := range OrgDomainTests {
+        if s := PublicSuffix(tt.domain); s != tt.suffix {
+            t.Errorf("PublicSuffix for %q was %q \n want %q\n", tt.domain, s, tt.suffix)
+        }
+        if o := OrgDomain(tt.domain); o != tt.org {
+            t.Errorf("OrgDomain for %q was %q \n want %q\n", tt.domain, o, tt.org)
+        }
+    }
+
+    if sameOrg("notbank.com", "bank.com") || !sameOrg("mail.bank.com", "bank.com") {
+        t.Errorf("sameOrg compared raw suffixes")
+    }
+}
+
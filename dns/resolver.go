@@ -0,0 +1,306 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Every DNS lookup made by the parsers and scorers goes through a Resolver.
+// Names which don't exist, or have no records of the requested type, return a *net.DNSError with IsNotFound set.
+type Resolver interface {
+	LookupTXT(name string) ([]string, error)
+	LookupIP(network string, host string) ([]net.IP, error) //network is "ip" (A and AAAA), "ip4" (A) or "ip6" (AAAA)
+	LookupMX(name string) ([]*net.MX, error)
+	LookupAddr(addr string) ([]string, error) //PTR
+	LookupCNAME(name string) (string, error)
+	LookupNS(name string) ([]*net.NS, error)
+	LookupSOA(name string) (*SOA, error)
+	LookupCAA(name string) ([]*CAA, error)
+	LookupTLSA(name string) ([]*TLSA, error)
+}
+
+type SOA struct {
+	NS      string
+	MBox    string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	MinTTL  uint32
+}
+
+// See https://tools.ietf.org/html/rfc8659
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// See https://tools.ietf.org/html/rfc6698
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Certificate  []byte
+}
+
+var DefaultResolver Resolver = &NetResolver{Resolver: net.DefaultResolver}
+
+/*
+   The default Resolver, backed by the host's resolver through net.Resolver.
+   net.Resolver can't ask for SOA, CAA or TLSA records, so those are queried directly from Servers,
+   which defaults to the nameservers in /etc/resolv.conf
+*/
+type NetResolver struct {
+	Resolver *net.Resolver
+	Servers  []string
+	Timeout  time.Duration
+}
+
+func (r *NetResolver) LookupTXT(name string) ([]string, error) {
+	return r.Resolver.LookupTXT(context.Background(), name)
+}
+
+func (r *NetResolver) LookupIP(network string, host string) ([]net.IP, error) {
+	return r.Resolver.LookupIP(context.Background(), network, host)
+}
+
+func (r *NetResolver) LookupMX(name string) ([]*net.MX, error) {
+	return r.Resolver.LookupMX(context.Background(), name)
+}
+
+func (r *NetResolver) LookupAddr(addr string) ([]string, error) {
+	return r.Resolver.LookupAddr(context.Background(), addr)
+}
+
+func (r *NetResolver) LookupCNAME(name string) (string, error) {
+	return r.Resolver.LookupCNAME(context.Background(), name)
+}
+
+func (r *NetResolver) LookupNS(name string) ([]*net.NS, error) {
+	return r.Resolver.LookupNS(context.Background(), name)
+}
+
+func (r *NetResolver) LookupSOA(name string) (*SOA, error) {
+	answers, err := r.query(name, dnsmessage.TypeSOA)
+	if err != nil {
+		return nil, err
+	}
+
+	soa := answers[0].Body.(*dnsmessage.SOAResource)
+	return &SOA{soa.NS.String(), soa.MBox.String(), soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.MinTTL}, nil
+}
+
+func (r *NetResolver) LookupCAA(name string) (caas []*CAA, err error) {
+	answers, err := r.query(name, typeCAA)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range answers {
+		body, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		if caa := parseCAA(body.Data); caa != nil {
+			caas = append(caas, caa)
+		}
+	}
+	return
+}
+
+func (r *NetResolver) LookupTLSA(name string) (tlsas []*TLSA, err error) {
+	answers, err := r.query(name, typeTLSA)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range answers {
+		body, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		if tlsa := parseTLSA(body.Data); tlsa != nil {
+			tlsas = append(tlsas, tlsa)
+		}
+	}
+	return
+}
+
+const (
+	typeTLSA dnsmessage.Type = 52
+	typeCAA  dnsmessage.Type = 257
+)
+
+// flags (1 byte), tag length (1 byte), tag, value
+func parseCAA(data []byte) *CAA {
+	if len(data) < 2 {
+		return nil
+	}
+	n := 2 + int(data[1])
+	if len(data) < n {
+		return nil
+	}
+	return &CAA{data[0], string(data[2:n]), string(data[n:])}
+}
+
+// usage, selector, matching type (1 byte each), certificate association data
+func parseTLSA(data []byte) *TLSA {
+	if len(data) < 3 {
+		return nil
+	}
+	return &TLSA{data[0], data[1], data[2], data[3:]}
+}
+
+// Sends a single question to each server in turn, returning the answers of the requested type
+func (r *NetResolver) query(name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+
+	servers := r.Servers
+	if len(servers) == 0 {
+		servers = resolvConfServers()
+	}
+
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+
+	lastErr := &net.DNSError{Err: "no nameservers available", Name: name, IsTemporary: true}
+	for _, server := range servers {
+		resp, err := r.exchange(server, packed)
+		if err != nil {
+			lastErr = &net.DNSError{Err: err.Error(), Name: name, Server: server, IsTemporary: true}
+			continue
+		}
+		if resp.ID != msg.ID {
+			lastErr = &net.DNSError{Err: "mismatched response id", Name: name, Server: server, IsTemporary: true}
+			continue
+		}
+
+		switch resp.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+		default:
+			lastErr = &net.DNSError{Err: resp.RCode.String(), Name: name, Server: server, IsTemporary: true}
+			continue
+		}
+
+		var answers []dnsmessage.Resource
+		for _, a := range resp.Answers {
+			if a.Header.Type == qtype {
+				answers = append(answers, a)
+			}
+		}
+		if len(answers) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+		}
+		return answers, nil
+	}
+
+	return nil, lastErr
+}
+
+// Asks over UDP first, retrying over TCP if the answer was truncated
+func (r *NetResolver) exchange(server string, packed []byte) (*dnsmessage.Message, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(dnsmessage.Message)
+	if err := resp.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	if !resp.Truncated {
+		return resp, nil
+	}
+
+	tcp, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer tcp.Close()
+	tcp.SetDeadline(time.Now().Add(timeout))
+
+	//messages over TCP are prefixed with their length
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(packed)))
+	if _, err := tcp.Write(append(length, packed...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(tcp, length); err != nil {
+		return nil, err
+	}
+	buf = make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(tcp, buf); err != nil {
+		return nil, err
+	}
+
+	resp = new(dnsmessage.Message)
+	return resp, resp.Unpack(buf)
+}
+
+func resolvConfServers() (servers []string) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return []string{"127.0.0.1:53"}
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
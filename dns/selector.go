@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Selectors used by common mail providers and ESPs, and generic ones people tend to pick
+var DefaultSelectors = []string{
+	//generic
+	"default", "dkim", "dkim1", "dkim2", "mail", "email", "smtp", "mx", "key1", "key2", "k1", "k2", "k3",
+	"s1", "s2", "s1024", "s2048", "sel1", "sel2", "sig1", "domainkey", "primary", "secondary",
+	//Google Workspace and Microsoft 365
+	"google", "selector1", "selector2",
+	//ESPs and SaaS senders
+	"mandrill", "mailjet", "mxvault", "everlytickey1", "everlytickey2", "pm", "cm", "hs1", "hs2",
+	"zendesk1", "zendesk2", "fd", "fd2", "sendgrid", "smtpapi", "mailgun", "mg", "krs", "sparkpost",
+	"scph0120", "sfmc", "et", "exacttarget", "turbo-smtp", "protonmail", "protonmail2", "protonmail3",
+	"zoho", "zmail", "yandex", "fm1", "fm2", "fm3", "mesmtp", "qualtrics", "salesforce", "ctct1", "ctct2",
+}
+
+/*
+   Selectors which embed a date, as rotated keys often do, for every year from first to last:
+   "2016", "201601".."201612", "2016q1".."2016q4" and the s1024-2016-q1 style used by e.g. Facebook.
+*/
+func DatedSelectors(first int, last int) (selectors []string) {
+	for y := first; y <= last; y++ {
+		selectors = append(selectors, fmt.Sprint(y))
+		for m := 1; m <= 12; m++ {
+			selectors = append(selectors, fmt.Sprintf("%d%02d", y, m))
+		}
+		for q := 1; q <= 4; q++ {
+			selectors = append(selectors, fmt.Sprintf("%dq%d", y, q),
+				fmt.Sprintf("s1024-%d-q%d", y, q), fmt.Sprintf("s2048-%d-q%d", y, q))
+		}
+	}
+	return
+}
+
+// A published DKIM key found by ProbeDKIMSelectors
+type DKIMSelector struct {
+	Selector string
+	Record   string
+	Profile  *DKIMDNSProfile
+	Key      *DKIMKey
+	Score    int
+	Wildcard bool  //the domain answers with this record for any selector, so it may not really be in use
+	Err      error //the lookup failed (e.g. SERVFAIL or a timeout), so whether there's a key is unknown
+}
+
+const (
+	probeWorkers  = 8
+	probeSelector = "bankrank-probe-nonexistent"
+)
+
+/*
+   Looks for keys under <selector>._domainkey.<domain> for each of selectors, returning those found
+   in the order of selectors. A nil selectors probes DefaultSelectors and the dated ones of the last five years.
+   Domains which answer for any selector (a wildcard record) only have their distinct keys reported,
+   each under the first selector returning it. Selectors whose lookup failed are returned with Err set.
+*/
+func ProbeDKIMSelectors(r Resolver, domain string, selectors []string) []DKIMSelector {
+	domain = normalDomain(domain)
+	if selectors == nil {
+		year := time.Now().Year()
+		selectors = append(append([]string{}, DefaultSelectors...), DatedSelectors(year-5, year)...)
+	}
+
+	var results []DKIMSelector
+	wildcard, err := lookupDKIMKey(r, probeSelector, domain)
+	if err != nil {
+		results = append(results, DKIMSelector{Selector: probeSelector, Err: err})
+	}
+
+	found := make([]*DKIMSelector, len(selectors))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < probeWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				record, err := lookupDKIMKey(r, selectors[i], domain)
+				if err != nil {
+					found[i] = &DKIMSelector{Selector: selectors[i], Err: err}
+					continue
+				}
+				if record == "" {
+					continue
+				}
+				p := ParseDKIMDNS(record)
+				found[i] = &DKIMSelector{selectors[i], record, p, ParseDKIMKey(p), scoreDKIMDNS(p), record == wildcard, nil}
+			}
+		}()
+	}
+	for i := range selectors {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	records := make(map[string]bool)
+	for _, s := range found {
+		if s == nil || seen[s.Selector] {
+			continue
+		}
+		seen[s.Selector] = true
+		if wildcard != "" && s.Err == nil {
+			if records[s.Record] {
+				continue
+			}
+			records[s.Record] = true
+		}
+		results = append(results, *s)
+	}
+	return results
+}
+
+// The key record at <selector>._domainkey.<domain>, or "" if there's none which looks like a key
+func lookupDKIMKey(r Resolver, selector string, domain string) (string, error) {
+	txts, err := r.LookupTXT(selector + "._domainkey." + domain)
+	if isNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, txt := range txts {
+		if _, ok := parseParams(txt)["p"]; ok {
+			return txt, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,82 @@
+package dns
+
+import (
+    "net"
+    "reflect"
+    "testing"
+)
+
+var ProbeDKIMSelectorsTests = []struct {
+    domain    string
+    selectors []string
+
+    found     []string
+    strengths []int
+}{
+    {"bank.com", []string{"google", "selector1", "selector2", "dated", "selector1"},
+     []string{"selector1", "selector2"}, []int{KEY_WEAK, KEY_BROKEN}},
+    {"facebookmail.com", DatedSelectors(2012, 2014), []string{"s1024-2013-q3"}, []int{KEY_WEAK}},
+    //each distinct key once, including the wildcard's
+    {"WILD.com.", []string{"mail", "google", "k1", "k2"}, []string{"google", "k1"}, []int{KEY_BROKEN, KEY_WEAK}},
+    {"nonexistent.com", nil, nil, nil},
+}
+
+func TestProbeDKIMSelectors(t *testing.T) {
+    for _, test := range ProbeDKIMSelectorsTests {
+        var found []string
+        var strengths []int
+        for _, s := range ProbeDKIMSelectors(testResolver, test.domain, test.selectors) {
+            if s.Err != nil {
+                t.Errorf("ProbeDKIMSelectors(%q) failed looking up %q: %v\n", test.domain, s.Selector, s.Err)
+                continue
+            }
+            found = append(found, s.Selector)
+            strengths = append(strengths, s.Key.Strength)
+            if s.Profile == nil || s.Record == "" {
+                t.Errorf("ProbeDKIMSelectors(%q) returned %q without its record\n", test.domain, s.Selector)
+            }
+        }
+
+        if !reflect.DeepEqual(found, test.found) || !reflect.DeepEqual(strengths, test.strengths) {
+            t.Errorf("ProbeDKIMSelectors(%q) was %q %v \n want %q %v\n", test.domain, found, strengths, test.found, test.strengths)
+        }
+    }
+}
+
+//a resolver which fails with a temporary error for one name
+type failingResolver struct {
+    Resolver
+    name string
+}
+
+func (r failingResolver) LookupTXT(name string) ([]string, error) {
+    if name == r.name {
+        return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+    }
+    return r.Resolver.LookupTXT(name)
+}
+
+func TestProbeDKIMSelectorsErrors(t *testing.T) {
+    r := failingResolver{testResolver, "selector2._domainkey.bank.com"}
+    selectors := ProbeDKIMSelectors(r, "bank.com", []string{"selector1", "selector2"})
+    if len(selectors) != 2 || selectors[0].Err != nil || selectors[1].Err == nil || selectors[1].Selector != "selector2" {
+        t.Errorf("ProbeDKIMSelectors(%q) with selector2 failing was %+v \n want selector1 and selector2's error\n", "bank.com", selectors)
+    }
+
+    wild := ProbeDKIMSelectors(testResolver, "wild.com", []string{"google"})
+    if len(wild) != 1 || !wild[0].Wildcard {
+        t.Errorf("ProbeDKIMSelectors(%q) was %+v \n want google as the wildcard\n", "wild.com", wild)
+    }
+}
+
+func TestDatedSelectors(t *testing.T) {
+    selectors := DatedSelectors(2019, 2020)
+    if len(selectors) != 2*(1+12+3*4) {
+        t.Errorf("DatedSelectors(2019, 2020) returned %d selectors\n", len(selectors))
+    }
+    for _, want := range []string{"2019", "202012", "2020q3", "s2048-2019-q1"} {
+        if !contains(selectors, want) {
+            t.Errorf("DatedSelectors(2019, 2020) is missing %q\n", want)
+        }
+    }
+}
@@ -0,0 +1,669 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Names of the check_host() results as they appear in Received-SPF and Authentication-Results headers
+var SPFResults = map[int]string{
+	UNDEF:      "",
+	PASS:       "pass",
+	FAIL:       "fail",
+	SOFT_FAIL:  "softfail",
+	NEUTRAL:    "neutral",
+	NONE:       "none",
+	PERM_ERROR: "permerror",
+	TEMP_ERROR: "temperror",
+}
+
+// A single directive or modifier of an SPF record, see https://tools.ietf.org/html/rfc7208 section 4.6.1
+type spfTerm struct {
+	modifier  bool
+	qualifier int
+	name      string
+	domain    string     // domain-spec for include, a, mx, ptr, exists
+	network   *net.IPNet // ip4, ip6
+	cidr4     int
+	cidr6     int
+	value     string // modifier value
+}
+
+// Processing limits from https://tools.ietf.org/html/rfc7208 section 4.6.4
+const (
+	spfLookupLimit = 10
+	spfVoidLimit   = 2
+)
+
+// Counters shared by every nested include: and redirect= of a single evaluation
+type spfLimits struct {
+	lookups int
+	voids   int
+	chain   []string
+}
+
+// State for one check_host() evaluation
+type spfCheck struct {
+	spfLimits
+	r      Resolver
+	ip     net.IP
+	sender string
+	helo   string
+}
+
+var spfModifier = regexp.MustCompile("^([A-Za-z][A-Za-z0-9_.-]*)=(.*)$")
+var spfDualCIDR = regexp.MustCompile("^(.*?)(?:/([0-9]+))?(?://([0-9]+))?$")
+
+/*
+   check_host() from https://tools.ietf.org/html/rfc7208 section 4
+   ip     : the IP address of the SMTP client that is emitting the mail
+   domain : the domain that provides the sought-after authorization information (initially the MAIL FROM or HELO domain)
+   sender : the MAIL FROM or HELO identity
+   helo   : the HELO/EHLO domain the client gave, which %{h} expands to, "" if it isn't known
+
+   explanation is the expanded exp= string of a FAIL result, if the domain publishes one.
+   The returned error explains any PERM_ERROR or TEMP_ERROR result.
+*/
+func CheckHost(r Resolver, ip net.IP, domain string, sender string, helo string) (result int, explanation string, err error) {
+	c := newSPFCheck(r, ip, sender, helo)
+	return c.checkHost(domain)
+}
+
+// Evaluates an SPF record that has already been fetched for domain
+func EvaluateSPF(r Resolver, record string, ip net.IP, domain string, sender string, helo string) (result int, explanation string, err error) {
+	c := newSPFCheck(r, ip, sender, helo)
+	return c.evaluate(record, domain)
+}
+
+/*
+   A sender without a local-part is treated as "postmaster", see https://tools.ietf.org/html/rfc7208 section 4.3
+   When only the HELO identity is being checked it is also what %{h} expands to.
+   Without a HELO domain %{h} falls back to the sender's domain, the best guess there is.
+*/
+func newSPFCheck(r Resolver, ip net.IP, sender string, helo string) *spfCheck {
+	c := &spfCheck{r: r, ip: ip, sender: sender, helo: strings.TrimSuffix(helo, ".")}
+
+	at := strings.LastIndex(sender, "@")
+	switch {
+	case at == -1:
+		if c.helo == "" {
+			c.helo = sender
+		}
+		c.sender = "postmaster@" + sender
+	case at == 0:
+		c.sender = "postmaster" + sender
+	}
+	if c.helo == "" {
+		c.helo = c.sender[strings.LastIndex(c.sender, "@")+1:]
+	}
+
+	return c
+}
+
+func (c *spfCheck) checkHost(domain string) (int, string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if !validDomain(domain) {
+		return NONE, "", nil
+	}
+
+	if err := c.enter(domain); err != nil {
+		return PERM_ERROR, "", err
+	}
+	defer c.leave()
+
+	record, result, err := lookupSPF(c.r, domain)
+	if result != UNDEF {
+		return result, "", err
+	}
+
+	return c.evaluate(record, domain)
+}
+
+// Counts a DNS-querying term, returns an error once the lookup limit is exceeded
+func (l *spfLimits) lookup(term string) error {
+	l.lookups++
+	if l.lookups > spfLookupLimit {
+		return fmt.Errorf("%s exceeds the limit of %d DNS lookups", term, spfLookupLimit)
+	}
+	return nil
+}
+
+// Counts a lookup that returned NXDOMAIN or no answers
+func (l *spfLimits) void(term string) error {
+	l.voids++
+	if l.voids > spfVoidLimit {
+		return fmt.Errorf("%s exceeds the limit of %d void lookups", term, spfVoidLimit)
+	}
+	return nil
+}
+
+// Detects include: and redirect= loops, which would otherwise only be caught by the lookup limit
+func (l *spfLimits) enter(domain string) error {
+	for _, d := range l.chain {
+		if strings.EqualFold(d, domain) {
+			return fmt.Errorf("loop detected: %s -> %s", strings.Join(l.chain, " -> "), domain)
+		}
+	}
+	l.chain = append(l.chain, domain)
+	return nil
+}
+
+func (l *spfLimits) leave() {
+	l.chain = l.chain[:len(l.chain)-1]
+}
+
+// Finds the single "v=spf1" TXT record for domain, see https://tools.ietf.org/html/rfc7208 section 4.5
+func lookupSPF(r Resolver, domain string) (record string, result int, err error) {
+	txts, err := r.LookupTXT(domain)
+	if err != nil {
+		if isNotFound(err) {
+			return "", NONE, nil
+		}
+		return "", TEMP_ERROR, err
+	}
+
+	var records []string
+	for _, txt := range txts {
+		if strings.EqualFold(txt, "v=spf1") || strings.HasPrefix(strings.ToLower(txt), "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+
+	switch len(records) {
+	case 0:
+		return "", NONE, nil
+	case 1:
+		return records[0], UNDEF, nil
+	default:
+		return "", PERM_ERROR, fmt.Errorf("%s has %d SPF records", domain, len(records))
+	}
+}
+
+func (c *spfCheck) evaluate(record string, domain string) (int, string, error) {
+	terms, err := parseSPFTerms(record)
+	if err != nil {
+		return PERM_ERROR, "", err
+	}
+
+	//modifiers may appear anywhere in the record
+	var redirect, exp *spfTerm
+	for i, t := range terms {
+		switch {
+		case t.modifier && t.name == "redirect":
+			redirect = &terms[i]
+		case t.modifier && t.name == "exp":
+			exp = &terms[i]
+		}
+	}
+
+	for _, t := range terms {
+		if t.modifier {
+			continue
+		}
+
+		match, result, err := c.match(&t, domain)
+		if result != UNDEF {
+			return result, "", err
+		}
+		if match {
+			if t.qualifier == FAIL && exp != nil {
+				return FAIL, c.explain(exp.value, domain), nil
+			}
+			return t.qualifier, "", nil
+		}
+	}
+
+	//redirect= is only consulted when no mechanism matched, and is ignored if there is an "all"
+	if redirect != nil {
+		target, err := c.domainSpec(redirect.value, domain)
+		if err != nil {
+			return PERM_ERROR, "", err
+		}
+		if err := c.lookup("redirect=" + target); err != nil {
+			return PERM_ERROR, "", err
+		}
+		//the explanation of the redirect target is used, not ours
+		result, explanation, err := c.checkHost(target)
+		if result == NONE {
+			return PERM_ERROR, "", fmt.Errorf("redirect=%s has no SPF record", target)
+		}
+		return result, explanation, err
+	}
+
+	return NEUTRAL, "", nil
+}
+
+// Fetches and expands the explanation string, any failure just means there's no explanation
+// See https://tools.ietf.org/html/rfc7208 section 6.2
+func (c *spfCheck) explain(spec string, domain string) string {
+	target, err := c.domainSpec(spec, domain)
+	if err != nil {
+		return ""
+	}
+
+	txts, err := c.r.LookupTXT(target)
+	if err != nil || len(txts) != 1 {
+		return ""
+	}
+
+	explanation, err := c.expand(txts[0], domain, true)
+	if err != nil {
+		return ""
+	}
+	return explanation
+}
+
+// Expands a domain-spec, an empty spec means the current domain
+func (c *spfCheck) domainSpec(spec string, domain string) (string, error) {
+	if spec == "" {
+		return domain, nil
+	}
+
+	target, err := c.expand(spec, domain, false)
+	if err != nil {
+		return "", err
+	}
+
+	//over-long expansions are shortened by dropping labels from the left
+	for len(target) > 253 && strings.Contains(target, ".") {
+		target = target[strings.Index(target, ".")+1:]
+	}
+	return target, nil
+}
+
+// Returns whether the mechanism matches c.ip, result is set when evaluation must stop with an error result
+func (c *spfCheck) match(t *spfTerm, domain string) (match bool, result int, err error) {
+	target, err := c.domainSpec(t.domain, domain)
+	if err != nil {
+		return false, PERM_ERROR, err
+	}
+
+	switch t.name {
+	case "include", "a", "mx", "ptr", "exists":
+		if err := c.lookup(t.name + ":" + target); err != nil {
+			return false, PERM_ERROR, err
+		}
+	}
+
+	switch t.name {
+	case "all":
+		return true, UNDEF, nil
+	case "include":
+		r, _, err := c.checkHost(target)
+		switch r {
+		case PASS:
+			return true, UNDEF, nil
+		case FAIL, SOFT_FAIL, NEUTRAL:
+			return false, UNDEF, nil
+		case TEMP_ERROR:
+			return false, TEMP_ERROR, err
+		default:
+			if err == nil {
+				err = fmt.Errorf("include:%s has no usable SPF record", target)
+			}
+			return false, PERM_ERROR, err
+		}
+	case "a":
+		match, result, err := c.matchHost(target, t)
+		if result == NONE {
+			if err := c.void("a:" + target); err != nil {
+				return false, PERM_ERROR, err
+			}
+			return false, UNDEF, nil
+		}
+		return match, result, err
+	case "mx":
+		mxs, err := c.r.LookupMX(target)
+		if err != nil && !isNotFound(err) {
+			return false, TEMP_ERROR, err
+		}
+		if len(mxs) == 0 {
+			if err := c.void("mx:" + target); err != nil {
+				return false, PERM_ERROR, err
+			}
+			return false, UNDEF, nil
+		}
+		if len(mxs) > 10 {
+			return false, PERM_ERROR, fmt.Errorf("mx:%s has more than 10 MX records", target)
+		}
+		for _, mx := range mxs {
+			match, result, err := c.matchHost(mx.Host, t)
+			if match || result == TEMP_ERROR {
+				return match, result, err
+			}
+		}
+		return false, UNDEF, nil
+	case "ptr":
+		return c.matchPTR(target)
+	case "ip4", "ip6":
+		return t.network.Contains(c.ip), UNDEF, nil
+	case "exists":
+		ips, err := lookupIP(c.r, "ip4", target)
+		if err != nil {
+			return false, TEMP_ERROR, err
+		}
+		if len(ips) == 0 {
+			if err := c.void("exists:" + target); err != nil {
+				return false, PERM_ERROR, err
+			}
+		}
+		return len(ips) > 0, UNDEF, nil
+	}
+
+	return false, PERM_ERROR, fmt.Errorf("unknown mechanism %q", t.name)
+}
+
+// Matches c.ip against the addresses of host, using the mechanism's dual-cidr-length
+// NONE is returned when host has no addresses at all
+func (c *spfCheck) matchHost(host string, t *spfTerm) (bool, int, error) {
+	network, bits, ones := "ip6", 128, t.cidr6
+	if c.ip.To4() != nil {
+		network, bits, ones = "ip4", 32, t.cidr4
+	}
+
+	ips, err := lookupIP(c.r, network, host)
+	if err != nil {
+		return false, TEMP_ERROR, err
+	}
+	if len(ips) == 0 {
+		return false, NONE, nil
+	}
+
+	mask := net.CIDRMask(ones, bits)
+	for _, ip := range ips {
+		n := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		if n.Contains(c.ip) {
+			return true, UNDEF, nil
+		}
+	}
+	return false, UNDEF, nil
+}
+
+// ptr is deprecated, but still seen in the wild, see https://tools.ietf.org/html/rfc7208 section 5.5
+func (c *spfCheck) matchPTR(target string) (bool, int, error) {
+	names, err := c.r.LookupAddr(c.ip.String())
+	if err != nil {
+		//failure of the reverse lookup just means no match
+		return false, UNDEF, nil
+	}
+
+	if len(names) > 10 {
+		names = names[:10]
+	}
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if !strings.EqualFold(name, target) && !strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(target)) {
+			continue
+		}
+
+		ips, err := c.r.LookupIP("ip", name)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(c.ip) {
+				return true, UNDEF, nil
+			}
+		}
+	}
+	return false, UNDEF, nil
+}
+
+// Looks up the A (ip4) or AAAA (ip6) records of host, a non-existent host simply has no addresses
+func lookupIP(r Resolver, network string, host string) ([]net.IP, error) {
+	ips, err := r.LookupIP(network, host)
+	if err != nil && isNotFound(err) {
+		return nil, nil
+	}
+	return ips, err
+}
+
+// Checks the length restrictions of https://tools.ietf.org/html/rfc7208 section 4.3
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses the whole record up front, any syntax error anywhere means PERM_ERROR without evaluating
+func parseSPFTerms(record string) (terms []spfTerm, err error) {
+	fields := strings.Fields(record)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "v=spf1") {
+		return nil, fmt.Errorf("%q is not an SPF version 1 record", record)
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range fields[1:] {
+		if m := spfModifier.FindStringSubmatch(f); m != nil {
+			name := strings.ToLower(m[1])
+			if (name == "redirect" || name == "exp") && seen[name] {
+				return nil, fmt.Errorf("%s= appears more than once", name)
+			}
+			seen[name] = true
+			terms = append(terms, spfTerm{modifier: true, name: name, value: m[2]})
+			continue
+		}
+
+		t, err := parseSPFMechanism(f)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+
+	return
+}
+
+func parseSPFMechanism(mech string) (t spfTerm, err error) {
+	t.qualifier = ParseMechPrefixes[string(mech[0])]
+	if t.qualifier == UNDEF {
+		t.qualifier = PASS
+	}
+	m := strings.TrimLeft(mech, "+-~?")
+
+	end := strings.IndexAny(m, ":/")
+	if end == -1 {
+		end = len(m)
+	}
+	t.name = strings.ToLower(m[:end])
+	arg := m[end:]
+	t.cidr4, t.cidr6 = 32, 128
+
+	switch t.name {
+	case "all":
+		if arg != "" {
+			return t, fmt.Errorf("%q takes no arguments", mech)
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+			return t, fmt.Errorf("%q requires a domain", mech)
+		}
+		t.domain = arg[1:]
+	case "ptr":
+		t.domain = strings.TrimPrefix(arg, ":")
+		if strings.HasPrefix(arg, "/") {
+			return t, fmt.Errorf("%q does not take a cidr length", mech)
+		}
+	case "a", "mx":
+		parts := spfDualCIDR.FindStringSubmatch(arg)
+		if parts[1] != "" && !strings.HasPrefix(parts[1], ":") {
+			return t, fmt.Errorf("malformed mechanism %q", mech)
+		}
+		t.domain = strings.TrimPrefix(parts[1], ":")
+		if parts[2] != "" {
+			t.cidr4, _ = strconv.Atoi(parts[2])
+		}
+		if parts[3] != "" {
+			t.cidr6, _ = strconv.Atoi(parts[3])
+		}
+		if t.cidr4 > 32 || t.cidr6 > 128 {
+			return t, fmt.Errorf("cidr length out of range in %q", mech)
+		}
+	case "ip4", "ip6":
+		if !strings.HasPrefix(arg, ":") {
+			return t, fmt.Errorf("%q requires a network", mech)
+		}
+		ipexpr := arg[1:]
+		if !strings.Contains(ipexpr, "/") {
+			if t.name == "ip4" {
+				ipexpr += "/32"
+			} else {
+				ipexpr += "/128"
+			}
+		}
+		ip, network, err := net.ParseCIDR(ipexpr)
+		if err != nil || (ip.To4() != nil) != (t.name == "ip4") {
+			return t, fmt.Errorf("malformed network in %q", mech)
+		}
+		t.network = network
+	default:
+		return t, fmt.Errorf("unknown mechanism %q", mech)
+	}
+
+	return
+}
+
+/*
+   Fetches the SPF record for domain and follows every include: and redirect= into Children,
+   so that everything the domain actually authorises can be seen and scored.
+   The lookup and void lookup limits of check_host() are enforced across the whole tree, and a PERM_ERROR anywhere
+   below a profile is reported on that profile too.
+*/
+func ResolveSPF(r Resolver, domain string, IP string) *SPFProfile {
+	l := &spfLimits{}
+	return l.resolve(r, domain, IP)
+}
+
+func (l *spfLimits) resolve(r Resolver, domain string, IP string) (p *SPFProfile) {
+	domain = strings.TrimSuffix(domain, ".")
+	p = &SPFProfile{Domain: domain, IP: IP}
+
+	if err := l.enter(domain); err != nil {
+		p.Result, p.Err = PERM_ERROR, err
+		return
+	}
+	defer l.leave()
+
+	record, result, err := lookupSPF(r, domain)
+	if result != UNDEF {
+		p.Result, p.Err = result, err
+		return
+	}
+
+	terms, err := parseSPFTerms(record)
+	if err != nil {
+		p.Record, p.Result, p.Err = record, PERM_ERROR, err
+		return
+	}
+
+	p = ParseSPF(r, record, IP)
+	p.Domain = domain
+	p.Children = make(map[string]*SPFProfile)
+	start := l.lookups
+	defer func() { p.Lookups = l.lookups - start }()
+
+	//as in check_host(), redirect= is ignored if there is an "all"
+	hasAll := false
+	for _, t := range terms {
+		hasAll = hasAll || (!t.modifier && t.name == "all")
+	}
+
+	network := "ip4"
+	if ip := net.ParseIP(IP); ip != nil && ip.To4() == nil {
+		network = "ip6"
+	}
+
+	for _, t := range terms {
+		var target string
+		switch {
+		case t.modifier && t.name == "redirect" && !hasAll:
+			target = t.value
+		case t.modifier:
+			continue
+		case t.name == "include":
+			target = t.domain
+		}
+
+		switch t.name {
+		case "include", "a", "mx", "ptr", "exists", "redirect":
+			if err := l.lookup(t.name + ":" + t.domain + t.value); err != nil {
+				p.Result, p.Err = PERM_ERROR, err
+				return
+			}
+		}
+
+		switch t.name {
+		case "a", "mx", "exists":
+			host := t.domain
+			if host == "" {
+				host = domain
+			}
+			if strings.Contains(host, "%") {
+				continue
+			}
+			void, err := voidLookup(r, t.name, network, host)
+			if err != nil {
+				p.Result, p.Err = TEMP_ERROR, err
+				return
+			}
+			if void {
+				if err := l.void(t.name + ":" + host); err != nil {
+					p.Result, p.Err = PERM_ERROR, err
+					return
+				}
+			}
+			continue
+		}
+
+		//targets using macros depend on the sender, so can only be followed by CheckHost
+		if target == "" || strings.Contains(target, "%") {
+			continue
+		}
+
+		child := l.resolve(r, target, IP)
+		p.Children[target] = child
+
+		switch child.Result {
+		case NONE:
+			//a missing record is a permerror for both include: and redirect=
+			p.Result, p.Err = PERM_ERROR, fmt.Errorf("%s has no SPF record", target)
+			return
+		case PERM_ERROR, TEMP_ERROR:
+			p.Result, p.Err = child.Result, child.Err
+			return
+		}
+	}
+
+	return
+}
+
+// Whether an a, mx or exists mechanism's lookup for host finds nothing, which check_host() counts as a void lookup
+func voidLookup(r Resolver, mechanism string, network string, host string) (bool, error) {
+	switch mechanism {
+	case "mx":
+		mxs, err := r.LookupMX(host)
+		if err != nil && !isNotFound(err) {
+			return false, err
+		}
+		return len(mxs) == 0, nil
+	case "exists":
+		network = "ip4"
+	}
+	ips, err := lookupIP(r, network, host)
+	return len(ips) == 0 && err == nil, err
+}
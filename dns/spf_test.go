@@ -0,0 +1,212 @@
+package dns
+
+import (
+    "net"
+    "testing"
+)
+
+//These records only use mechanisms which don't require a lookup
+var EvaluateSPFTests = []struct {
+    record string
+    ip string
+
+    result int
+}{
+    {record: "v=spf1 ip4:192.168.0.0/16 -all",
+     ip: "192.168.1.1",
+     result: PASS,
+    },
+    {record: "v=spf1 ip4:192.168.0.0/16 -all",
+     ip: "10.0.0.1",
+     result: FAIL,
+    },
+    {record: "v=spf1 ip4:192.168.0.0/16 ~all",
+     ip: "10.0.0.1",
+     result: SOFT_FAIL,
+    },
+    {record: "v=spf1 ?ip4:10.0.0.1 -all",
+     ip: "10.0.0.1",
+     result: NEUTRAL,
+    },
+    {record: "v=spf1 -ip4:10.0.0.1 +all",
+     ip: "10.0.0.1",
+     result: FAIL,
+    },
+    {record: "v=spf1 ip6:1080::8:800:200C:417A/96 -all",
+     ip: "1080::8:800:68.0.3.1",
+     result: PASS,
+    },
+    {record: "v=spf1 ip4:192.168.0.1",
+     ip: "10.0.0.1",
+     result: NEUTRAL,
+    }, //no match and no "all" defaults to neutral
+    {record: "v=spf1 ip4:192.168.0.1/33 -all",
+     ip: "10.0.0.1",
+     result: PERM_ERROR,
+    },
+    {record: "v=spf1 ip4:1080::1 -all",
+     ip: "10.0.0.1",
+     result: PERM_ERROR,
+    },
+    {record: "v=spf1 -all foo:bar",
+     ip: "10.0.0.1",
+     result: PERM_ERROR,
+    }, //syntax errors anywhere are a permerror, even after "all"
+    {record: "v=spf1 redirect=a.com redirect=b.com",
+     ip: "10.0.0.1",
+     result: PERM_ERROR,
+    },
+    {record: "v=spf1 include -all",
+     ip: "10.0.0.1",
+     result: PERM_ERROR,
+    },
+    {record: "v=spf1 ip4:10.0.0.1 unknown=modifier -all",
+     ip: "10.0.0.1",
+     result: PASS,
+    },
+}
+
+func TestEvaluateSPF(t *testing.T) {
+    for _, tt := range EvaluateSPFTests {
+        if r, _, err := EvaluateSPF(testResolver, tt.record, net.ParseIP(tt.ip), "example.com", "user@example.com", ""); r != tt.result {
+            t.Errorf("EvaluateSPF for %q was %s (%v)\n want %s\n", tt.record, SPFResults[r], err, SPFResults[tt.result])
+        }
+    }
+}
+
+var ParseSPFMechanismTests = []struct {
+    mech string
+
+    name string
+    domain string
+    cidr4 int
+    cidr6 int
+}{
+    {mech: "a", name: "a", cidr4: 32, cidr6: 128},
+    {mech: "a/24", name: "a", cidr4: 24, cidr6: 128},
+    {mech: "-a:example.com//64", name: "a", domain: "example.com", cidr4: 32, cidr6: 64},
+    {mech: "mx:example.com/24//64", name: "mx", domain: "example.com", cidr4: 24, cidr6: 64},
+    {mech: "~include:_spf.google.com", name: "include", domain: "_spf.google.com", cidr4: 32, cidr6: 128},
+    {mech: "ptr", name: "ptr", cidr4: 32, cidr6: 128},
+}
+
+func TestParseSPFMechanism(t *testing.T) {
+    for _, tt := range ParseSPFMechanismTests {
+        m, err := parseSPFMechanism(tt.mech)
+        if err != nil || m.name != tt.name || m.domain != tt.domain || m.cidr4 != tt.cidr4 || m.cidr6 != tt.cidr6 {
+            t.Errorf("parseSPFMechanism for %q was %+v (%v)\n", tt.mech, m, err)
+        }
+    }
+}
+
+func TestSPFLimits(t *testing.T) {
+    l := &spfLimits{}
+
+    for i := 0; i < spfLookupLimit; i++ {
+        if err := l.lookup("a"); err != nil {
+            t.Errorf("lookup %d was %v\n want nil\n", i+1, err)
+        }
+    }
+    if err := l.lookup("a"); err == nil {
+        t.Errorf("lookup %d was nil\n want an error\n", spfLookupLimit+1)
+    }
+
+    for i := 0; i < spfVoidLimit; i++ {
+        if err := l.void("a"); err != nil {
+            t.Errorf("void %d was %v\n want nil\n", i+1, err)
+        }
+    }
+    if err := l.void("a"); err == nil {
+        t.Errorf("void %d was nil\n want an error\n", spfVoidLimit+1)
+    }
+
+    l.enter("example.com")
+    l.enter("_spf.example.com")
+    if err := l.enter("EXAMPLE.com"); err == nil {
+        t.Errorf("enter for a loop was nil\n want an error\n")
+    }
+    l.leave()
+    if err := l.enter("_spf.example.com"); err != nil {
+        t.Errorf("enter after leave was %v\n want nil\n", err)
+    }
+}
+
+//Records are in testResolver
+var CheckHostTests = []struct {
+    domain string
+    ip string
+
+    result int
+    explanation string
+}{
+    {domain: "bank.com", ip: "192.0.2.10", result: PASS}, //a
+    {domain: "bank.com", ip: "192.0.2.20", result: PASS}, //mx
+    {domain: "bank.com", ip: "2001:db8::20", result: PASS}, //mx, IPv6
+    {domain: "bank.com", ip: "198.51.100.7", result: PASS}, //include
+    {domain: "bank.com", ip: "203.0.113.5", result: PASS}, //exists with a macro
+    {domain: "bank.com", ip: "10.0.0.1", result: FAIL},
+    {domain: "redirected.com", ip: "192.0.2.10", result: PASS},
+    {domain: "redirected.com", ip: "10.0.0.1", result: FAIL},
+    {domain: "explained.com", ip: "192.0.2.1", result: FAIL,
+     explanation: "192.0.2.1 is not one of explained.com's designated mail servers"},
+    {domain: "twice.com", ip: "10.0.0.1", result: PERM_ERROR},
+    {domain: "loop.com", ip: "10.0.0.1", result: PERM_ERROR},
+    {domain: "missing.com", ip: "10.0.0.1", result: PERM_ERROR},
+    {domain: "voids.com", ip: "10.0.0.1", result: PERM_ERROR},
+    {domain: "ignored.com", ip: "10.0.0.1", result: FAIL}, //redirect= is ignored with an "all"
+    {domain: "many.com", ip: "10.0.0.1", result: PERM_ERROR},
+    {domain: "nonexistent.com", ip: "10.0.0.1", result: NONE},
+    {domain: "localhost", ip: "10.0.0.1", result: NONE},
+}
+
+func TestCheckHost(t *testing.T) {
+    for _, tt := range CheckHostTests {
+        r, exp, err := CheckHost(testResolver, net.ParseIP(tt.ip), tt.domain, "user@"+tt.domain, "")
+        if r != tt.result || exp != tt.explanation {
+            t.Errorf("CheckHost for %s from %s was %s %q (%v)\n want %s %q\n", tt.domain, tt.ip, SPFResults[r], exp, err, SPFResults[tt.result], tt.explanation)
+        }
+    }
+}
+
+func TestCheckHostHELO(t *testing.T) {
+    //%{h} is the HELO domain, not the sender's
+    if r, _, err := CheckHost(testResolver, net.ParseIP("10.0.0.1"), "helo.com", "user@helo.com", "mail.example.org"); r != PASS {
+        t.Errorf("CheckHost with HELO mail.example.org was %s (%v)\n want pass\n", SPFResults[r], err)
+    }
+    if r, _, err := CheckHost(testResolver, net.ParseIP("10.0.0.1"), "helo.com", "user@helo.com", ""); r != FAIL {
+        t.Errorf("CheckHost without a HELO was %s (%v)\n want fail\n", SPFResults[r], err)
+    }
+}
+
+var ResolveSPFTests = []struct {
+    domain string
+
+    result int
+    lookups int
+    children []string
+}{
+    {domain: "bank.com", lookups: 4, children: []string{"_spf.esp.com"}},
+    {domain: "redirected.com", lookups: 5, children: []string{"bank.com"}},
+    {domain: "loop.com", result: PERM_ERROR, lookups: 2, children: []string{"loop2.com"}},
+    {domain: "missing.com", result: PERM_ERROR, lookups: 1, children: []string{"nonexistent.com"}},
+    {domain: "many.com", result: PERM_ERROR, lookups: 11, children: []string{"_spf.esp.com"}},
+    {domain: "voids.com", result: PERM_ERROR, lookups: 3},
+    {domain: "ignored.com", lookups: 0},
+    {domain: "nonexistent.com", result: NONE},
+}
+
+func TestResolveSPF(t *testing.T) {
+    for _, tt := range ResolveSPFTests {
+        p := ResolveSPF(testResolver, tt.domain, "192.0.2.1")
+
+        if p.Result != tt.result || p.Lookups != tt.lookups || len(p.Children) != len(tt.children) {
+            t.Errorf("ResolveSPF for %s was %s with %d lookups and %d children (%v)\n want %s with %d lookups and %d children\n",
+                     tt.domain, SPFResults[p.Result], p.Lookups, len(p.Children), p.Err, SPFResults[tt.result], tt.lookups, len(tt.children))
+        }
+        for _, child := range tt.children {
+            if p.Children[child] == nil {
+                t.Errorf("ResolveSPF for %s has no child %s\n", tt.domain, child)
+            }
+        }
+    }
+}
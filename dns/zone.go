@@ -0,0 +1,246 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+   An in-memory Resolver backed by a zone file, so that parsers and scorers can be tested offline.
+   Each line holds one record in the usual master file format, with the TTL and class being optional:
+
+   example.com.           300 IN TXT  "v=spf1 ip4:192.0.2.0/24 -all"
+   example.com.               IN MX   10 mail.example.com.
+   mail.example.com.          IN A    192.0.2.1
+   1.2.0.192.in-addr.arpa.    IN PTR  mail.example.com.
+
+   Everything after a ";" is a comment. Names are absolute, with or without the trailing dot.
+*/
+type ZoneResolver struct {
+	records map[string]map[string][][]string //name -> type -> rdata fields
+}
+
+func ParseZone(zone string) (*ZoneResolver, error) {
+	z := &ZoneResolver{records: make(map[string]map[string][][]string)}
+
+	scanner := bufio.NewScanner(strings.NewReader(zone))
+	for n := 1; scanner.Scan(); n++ {
+		fields, err := zoneFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected <name> [ttl] [class] <type> <rdata>", n)
+		}
+
+		name := zoneName(fields[0])
+		fields = fields[1:]
+		if _, err := strconv.ParseUint(fields[0], 10, 32); err == nil {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 && strings.EqualFold(fields[0], "IN") {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing rdata", n)
+		}
+
+		rtype := strings.ToUpper(fields[0])
+		if z.records[name] == nil {
+			z.records[name] = make(map[string][][]string)
+		}
+		z.records[name][rtype] = append(z.records[name][rtype], fields[1:])
+	}
+
+	return z, scanner.Err()
+}
+
+// Splits a line into fields, keeping quoted strings together and dropping comments
+func zoneFields(line string) (fields []string, err error) {
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ';':
+			return
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := i + 1
+			var b strings.Builder
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' && end+1 < len(line) {
+					end++
+				}
+				b.WriteByte(line[end])
+			}
+			if end == len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, b.String())
+			i = end + 1
+		default:
+			end := strings.IndexAny(line[i:], " \t;")
+			if end == -1 {
+				end = len(line) - i
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+		}
+	}
+	return
+}
+
+func zoneName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func (z *ZoneResolver) lookup(name string, rtype string) ([][]string, error) {
+	rdata := z.records[zoneName(name)][rtype]
+	if len(rdata) == 0 {
+		return nil, notFound(name)
+	}
+	return rdata, nil
+}
+
+// The character-strings of a record are joined together, as net.LookupTXT does
+func (z *ZoneResolver) LookupTXT(name string) (txts []string, err error) {
+	rdata, err := z.lookup(name, "TXT")
+	for _, fields := range rdata {
+		txts = append(txts, strings.Join(fields, ""))
+	}
+	return
+}
+
+func (z *ZoneResolver) LookupIP(network string, host string) (ips []net.IP, err error) {
+	var types []string
+	switch network {
+	case "ip4":
+		types = []string{"A"}
+	case "ip6":
+		types = []string{"AAAA"}
+	default:
+		types = []string{"A", "AAAA"}
+	}
+
+	for _, t := range types {
+		rdata, _ := z.lookup(host, t)
+		for _, fields := range rdata {
+			if ip := net.ParseIP(fields[0]); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, notFound(host)
+	}
+	return
+}
+
+func (z *ZoneResolver) LookupMX(name string) (mxs []*net.MX, err error) {
+	rdata, err := z.lookup(name, "MX")
+	for _, fields := range rdata {
+		if len(fields) < 2 {
+			continue
+		}
+		pref, _ := strconv.ParseUint(fields[0], 10, 16)
+		mxs = append(mxs, &net.MX{Host: fields[1], Pref: uint16(pref)})
+	}
+	return
+}
+
+// addr is an IP address, which is looked up under in-addr.arpa or ip6.arpa
+func (z *ZoneResolver) LookupAddr(addr string) (names []string, err error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+
+	rdata, err := z.lookup(reverseName(ip), "PTR")
+	for _, fields := range rdata {
+		names = append(names, fields[0])
+	}
+	return
+}
+
+func (z *ZoneResolver) LookupCNAME(name string) (string, error) {
+	rdata, err := z.lookup(name, "CNAME")
+	if err != nil {
+		return "", err
+	}
+	return rdata[0][0], nil
+}
+
+func (z *ZoneResolver) LookupNS(name string) (nss []*net.NS, err error) {
+	rdata, err := z.lookup(name, "NS")
+	for _, fields := range rdata {
+		nss = append(nss, &net.NS{Host: fields[0]})
+	}
+	return
+}
+
+func (z *ZoneResolver) LookupSOA(name string) (*SOA, error) {
+	rdata, err := z.lookup(name, "SOA")
+	if err != nil {
+		return nil, err
+	}
+
+	f := rdata[0]
+	if len(f) < 7 {
+		return nil, &net.DNSError{Err: "malformed SOA record", Name: name}
+	}
+	n := make([]uint32, 5)
+	for i := range n {
+		v, _ := strconv.ParseUint(f[i+2], 10, 32)
+		n[i] = uint32(v)
+	}
+	return &SOA{f[0], f[1], n[0], n[1], n[2], n[3], n[4]}, nil
+}
+
+func (z *ZoneResolver) LookupCAA(name string) (caas []*CAA, err error) {
+	rdata, err := z.lookup(name, "CAA")
+	for _, fields := range rdata {
+		if len(fields) < 3 {
+			continue
+		}
+		flag, _ := strconv.ParseUint(fields[0], 10, 8)
+		caas = append(caas, &CAA{uint8(flag), fields[1], fields[2]})
+	}
+	return
+}
+
+func (z *ZoneResolver) LookupTLSA(name string) (tlsas []*TLSA, err error) {
+	rdata, err := z.lookup(name, "TLSA")
+	for _, fields := range rdata {
+		if len(fields) < 4 {
+			continue
+		}
+		n := make([]uint8, 3)
+		for i := range n {
+			v, _ := strconv.ParseUint(fields[i], 10, 8)
+			n[i] = uint8(v)
+		}
+		cert, _ := hex.DecodeString(strings.Join(fields[3:], ""))
+		tlsas = append(tlsas, &TLSA{n[0], n[1], n[2], cert})
+	}
+	return
+}
+
+// e.g. 192.0.2.1 -> 1.2.0.192.in-addr.arpa
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	nibbles := strings.Split(ipMacro(ip), ".")
+	for i, j := 0, len(nibbles)-1; i < j; i, j = i+1, j-1 {
+		nibbles[i], nibbles[j] = nibbles[j], nibbles[i]
+	}
+	return strings.Join(nibbles, ".") + ".ip6.arpa"
+}
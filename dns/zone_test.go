@@ -0,0 +1,130 @@
+package dns
+
+import (
+    "net"
+    "reflect"
+    "testing"
+)
+
+//Shared by the tests of every lookup in this package
+var testResolver, _ = ParseZone(`
+; DKIM, both selectors publish the same 1024 bit key
+mail._domainkey.information.natwest.com.   IN TXT "k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDoclZ4XpNdyh8fvXDbHKcVvp63pZX42ceid9R2FHT/UnG4dAtVcBWxzgxdThgSFYevgDkKC40Z1Kfj4kMeJxjdAl11Cbe//iArMdM3bmTJNP3FzFA82gwhE36jUGYawqWF1LcJXb0QH+khp/tRehMnFI4pRmvGGW/51yaazeBX6wIDAQAB"
+s1024-2013-q3._domainkey.facebookmail.com. IN TXT "k=rsa; " "p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDoclZ4XpNdyh8fvXDbHKcVvp63pZX42ceid9R2FHT/UnG4dAtVcBWxzgxdThgSFYevgDkKC40Z1Kfj4kMeJxjdAl11Cbe//iArMdM3bmTJNP3FzFA82gwhE36jUGYawqWF1LcJXb0QH+khp/tRehMnFI4pRmvGGW/51yaazeBX6wIDAQAB"
+
+; DKIM selectors, selector2 has been revoked, twice has two key records and wild.com answers for any selector
+selector1._domainkey.bank.com. IN TXT "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDoclZ4XpNdyh8fvXDbHKcVvp63pZX42ceid9R2FHT/UnG4dAtVcBWxzgxdThgSFYevgDkKC40Z1Kfj4kMeJxjdAl11Cbe//iArMdM3bmTJNP3FzFA82gwhE36jUGYawqWF1LcJXb0QH+khp/tRehMnFI4pRmvGGW/51yaazeBX6wIDAQAB"
+selector2._domainkey.bank.com. IN TXT "v=DKIM1; p="
+dated._domainkey.bank.com.     IN TXT "some-verification=abc"
+twice._domainkey.bank.com.     IN TXT "v=DKIM1; p="
+twice._domainkey.bank.com.     IN TXT "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+bankrank-probe-nonexistent._domainkey.wild.com. IN TXT "v=DKIM1; p="
+google._domainkey.wild.com.    IN TXT "v=DKIM1; p="
+k1._domainkey.wild.com.        IN TXT "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDoclZ4XpNdyh8fvXDbHKcVvp63pZX42ceid9R2FHT/UnG4dAtVcBWxzgxdThgSFYevgDkKC40Z1Kfj4kMeJxjdAl11Cbe//iArMdM3bmTJNP3FzFA82gwhE36jUGYawqWF1LcJXb0QH+khp/tRehMnFI4pRmvGGW/51yaazeBX6wIDAQAB"
+
+; SPF
+bank.com.             300 IN TXT "v=spf1 a mx include:_spf.esp.com exists:%{i}._spf.bank.com -all"
+bank.com.             300 IN TXT "google-site-verification=abc"
+bank.com.             300 IN A   192.0.2.10
+bank.com.             300 IN MX  10 mail.bank.com.
+mail.bank.com.        300 IN A   192.0.2.20
+mail.bank.com.        300 IN AAAA 2001:db8::20
+_spf.esp.com.         300 IN TXT "v=spf1 ip4:198.51.100.0/24 ~all"
+203.0.113.5._spf.bank.com. IN A 127.0.0.2
+redirected.com.           IN TXT "v=spf1 redirect=bank.com"
+explained.com.            IN TXT "v=spf1 -all exp=explain.explained.com"
+explain.explained.com.    IN TXT "%{i} is not one of %{d}'s designated mail servers"
+twice.com.                IN TXT "v=spf1 -all"
+twice.com.                IN TXT "v=spf1 +all"
+loop.com.                 IN TXT "v=spf1 include:loop2.com -all"
+loop2.com.                IN TXT "v=spf1 include:loop.com -all"
+missing.com.              IN TXT "v=spf1 include:nonexistent.com -all"
+helo.com.                 IN TXT "v=spf1 exists:%{h}._spf.helo.com -all"
+mail.example.org._spf.helo.com. IN A 127.0.0.2
+ignored.com.              IN TXT "v=spf1 ip4:192.0.2.0/24 -all redirect=nonexistent.com"
+voids.com.                IN TXT "v=spf1 a:a.nonexistent.com a:b.nonexistent.com a:c.nonexistent.com -all"
+many.com.                 IN TXT "v=spf1 include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com include:_spf.esp.com -all"
+20.2.0.192.in-addr.arpa.  IN PTR mail.bank.com.
+
+; DMARC
+_dmarc.bank.com.          IN TXT "v=DMARC1; p=reject; rua=mailto:dmarc@bank.com"
+_dmarc.bank.co.uk.        IN TXT "v=DMARC1; p=quarantine"
+_dmarc.news.bank.co.uk.   IN TXT "v=DMARC1; p=none; rua=mailto:reports@esp.com"
+_dmarc.twice.com.         IN TXT "v=DMARC1; p=reject"
+_dmarc.twice.com.         IN TXT "v=DMARC1; p=none"
+_dmarc.mail.twice.com.    IN TXT "v=DMARC1; p=none"
+_dmarc.mail.twice.com.    IN TXT "v=DMARC1; p=reject"
+_dmarc.explained.com.     IN TXT "some-verification=abc"
+_dmarc.explained.com.     IN TXT "v=DMARC1 ; p=quarantine; rua=mailto:reports@esp.com,mailto:d@explained.com!50k; ruf=mailto:f@esp.com"
+explained.com._report._dmarc.esp.com. IN TXT "v=DMARC1"
+_dmarc.missing.com.       IN TXT "v=DMARC2; p=reject"
+_dmarc.missing.com.       IN TXT "v=DMARC10; p=reject"
+
+; Other record types
+bank.com.             IN NS    ns1.bank.com.
+bank.com.             IN SOA   ns1.bank.com. hostmaster.bank.com. 2016060101 7200 3600 1209600 300
+bank.com.             IN CAA   0 issue "letsencrypt.org"
+www.bank.com.         IN CNAME bank.com.
+_25._tcp.mail.bank.com. IN TLSA 3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6
+`)
+
+func TestParseZone(t *testing.T) {
+    if testResolver == nil {
+        t.Fatalf("ParseZone failed for the test zone")
+    }
+
+    if txts, err := testResolver.LookupTXT("_spf.esp.com"); !reflect.DeepEqual(txts, []string{"v=spf1 ip4:198.51.100.0/24 ~all"}) {
+        t.Errorf("LookupTXT was %q (%v)\n", txts, err)
+    }
+    if ips, err := testResolver.LookupIP("ip6", "mail.bank.com."); len(ips) != 1 || !ips[0].Equal(net.ParseIP("2001:db8::20")) {
+        t.Errorf("LookupIP was %v (%v)\n", ips, err)
+    }
+    if mxs, err := testResolver.LookupMX("bank.com"); len(mxs) != 1 || *mxs[0] != (net.MX{Host: "mail.bank.com.", Pref: 10}) {
+        t.Errorf("LookupMX was %v (%v)\n", mxs, err)
+    }
+    if names, err := testResolver.LookupAddr("192.0.2.20"); !reflect.DeepEqual(names, []string{"mail.bank.com."}) {
+        t.Errorf("LookupAddr was %q (%v)\n", names, err)
+    }
+    if cname, err := testResolver.LookupCNAME("www.bank.com"); cname != "bank.com." {
+        t.Errorf("LookupCNAME was %q (%v)\n", cname, err)
+    }
+    if soa, err := testResolver.LookupSOA("bank.com"); soa == nil || *soa != (SOA{"ns1.bank.com.", "hostmaster.bank.com.", 2016060101, 7200, 3600, 1209600, 300}) {
+        t.Errorf("LookupSOA was %+v (%v)\n", soa, err)
+    }
+    if caas, err := testResolver.LookupCAA("bank.com"); len(caas) != 1 || *caas[0] != (CAA{0, "issue", "letsencrypt.org"}) {
+        t.Errorf("LookupCAA was %+v (%v)\n", caas, err)
+    }
+    if tlsas, err := testResolver.LookupTLSA("_25._tcp.mail.bank.com"); len(tlsas) != 1 || tlsas[0].Usage != 3 || len(tlsas[0].Certificate) != 32 {
+        t.Errorf("LookupTLSA was %+v (%v)\n", tlsas, err)
+    }
+    if _, err := testResolver.LookupTXT("nonexistent.com"); !isNotFound(err) {
+        t.Errorf("LookupTXT for a missing name was %v\n want not found\n", err)
+    }
+}
+
+var ParseZoneErrorTests = []string{
+    `example.com. IN TXT "unterminated`,
+    `example.com. TXT`,
+}
+
+func TestParseZoneError(t *testing.T) {
+    for _, zone := range ParseZoneErrorTests {
+        if _, err := ParseZone(zone); err == nil {
+            t.Errorf("ParseZone for %q was nil\n want an error\n", zone)
+        }
+    }
+}
+
+func TestParseCAA(t *testing.T) {
+    if caa := parseCAA([]byte("\x80\x05issuecomodoca.com")); caa == nil || *caa != (CAA{128, "issue", "comodoca.com"}) {
+        t.Errorf("parseCAA was %+v\n", caa)
+    }
+    if caa := parseCAA([]byte("\x00\x09issue")); caa != nil {
+        t.Errorf("parseCAA for a short tag was %+v\n want nil\n", caa)
+    }
+    //a tag length of 254 or more mustn't wrap around
+    long := append([]byte{0, 254}, make([]byte, 300)...)
+    if caa := parseCAA(long); caa == nil || len(caa.Tag) != 254 || len(caa.Value) != 46 {
+        t.Errorf("parseCAA for a long tag was %+v\n", caa)
+    }
+}
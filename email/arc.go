@@ -0,0 +1,238 @@
+package email
+
+import (
+	dns "bankrank/dns"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const arcMaxInstances = 50
+
+// One hop of an Authenticated Received Chain, see https://tools.ietf.org/html/rfc8617 section 4
+type ARCSet struct {
+	Instance int
+
+	AAR Field //ARC-Authentication-Results
+	AMS Field //ARC-Message-Signature
+	AS  Field //ARC-Seal
+
+	Results   *AuthResults    //what the hop saw, parsed from AAR
+	Signature *DKIMSigProfile //parsed from AMS
+	Seal      *DKIMSigProfile //parsed from AS
+	CV        string          //the seal's chain validation status, "none", "pass" or "fail"
+}
+
+type ARCResult struct {
+	Result     int //dns.NONE, dns.PASS or dns.FAIL
+	OldestPass int //the lowest instance whose AMS still verifies, 0 if they all do
+	Sets       []ARCSet
+	Err        error
+}
+
+// Validates the ARC chain of a raw message
+func VerifyARC(r dns.Resolver, message []byte) (*ARCResult, error) {
+	m, err := ParseMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyARCChain(r, m.Fields, m.Body), nil
+}
+
+// See https://tools.ietf.org/html/rfc8617 section 5.2
+func VerifyARCChain(r dns.Resolver, fields []Field, body []byte) *ARCResult {
+	res := ARCResult{Result: dns.NONE}
+
+	fail := func(format string, args ...interface{}) *ARCResult {
+		res.Result, res.Err = dns.FAIL, fmt.Errorf(format, args...)
+		return &res
+	}
+
+	sets, err := collectARCSets(fields)
+	if err != nil {
+		return fail("%s", err)
+	}
+	if len(sets) == 0 {
+		return &res
+	}
+	res.Sets = sets
+	n := len(sets)
+
+	if sets[n-1].CV == "fail" {
+		return fail("instance %d sealed a failed chain", n)
+	}
+	for _, set := range sets {
+		want := "pass"
+		if set.Instance == 1 {
+			want = "none"
+		}
+		if set.CV != want {
+			return fail("instance %d has cv=%s, want cv=%s", set.Instance, set.CV, want)
+		}
+	}
+
+	//the newest message signature must verify, older ones are expected to break along the way
+	for i := n - 1; i >= 0; i-- {
+		err := verifyAMS(r, fields, body, sets[i])
+		if err == nil {
+			continue
+		}
+		if i == n-1 {
+			return fail("instance %d ARC-Message-Signature: %s", n, err)
+		}
+		res.OldestPass = i + 2
+		break
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		if err := verifyAS(r, sets[:i+1]); err != nil {
+			return fail("instance %d ARC-Seal: %s", i+1, err)
+		}
+	}
+
+	res.Result = dns.PASS
+	return &res
+}
+
+// Groups the ARC fields by instance, which must run from 1 without gaps and have exactly one of each field
+func collectARCSets(fields []Field) ([]ARCSet, error) {
+	byInstance := make(map[int]*ARCSet)
+
+	for _, f := range fields {
+		name := strings.ToLower(f.Name)
+		if !strings.HasPrefix(name, "arc-") {
+			continue
+		}
+
+		var i int
+		var err error
+		var existing Field
+		switch name {
+		case "arc-authentication-results":
+			i, err = aarInstance(f.Value())
+		case "arc-message-signature", "arc-seal":
+			i, err = strconv.Atoi(stripSpace(parseParams(f.Value())["i"]))
+		default:
+			continue
+		}
+		if err != nil || i < 1 || i > arcMaxInstances {
+			return nil, fmt.Errorf("%s has an invalid instance", f.Name)
+		}
+
+		set := byInstance[i]
+		if set == nil {
+			set = &ARCSet{Instance: i}
+			byInstance[i] = set
+		}
+		switch name {
+		case "arc-authentication-results":
+			existing, set.AAR = set.AAR, f
+		case "arc-message-signature":
+			existing, set.AMS = set.AMS, f
+		case "arc-seal":
+			existing, set.AS = set.AS, f
+		}
+		if existing.Name != "" {
+			return nil, fmt.Errorf("instance %d has more than one %s", i, f.Name)
+		}
+	}
+
+	sets := make([]ARCSet, len(byInstance))
+	for i := 1; i <= len(byInstance); i++ {
+		set := byInstance[i]
+		if set == nil {
+			return nil, fmt.Errorf("instance %d is missing", i)
+		}
+		if set.AAR.Name == "" || set.AMS.Name == "" || set.AS.Name == "" {
+			return nil, fmt.Errorf("instance %d is incomplete", i)
+		}
+
+		set.Signature = ParseDKIMSig(set.AMS.Value())
+		set.Seal = ParseDKIMSig(set.AS.Value())
+		set.CV = strings.ToLower(stripSpace(parseParams(set.AS.Value())["cv"]))
+		if v := set.AAR.Value(); strings.Contains(v, ";") {
+			set.Results, _ = ParseAuthResults(v[strings.Index(v, ";")+1:])
+		}
+		sets[i-1] = *set
+	}
+
+	return sets, nil
+}
+
+// ARC-Authentication-Results starts with "i=<instance>;"
+func aarInstance(v string) (int, error) {
+	split := strings.SplitN(v, ";", 2)
+	tag := strings.SplitN(split[0], "=", 2)
+	if len(tag) != 2 || strings.TrimSpace(tag[0]) != "i" {
+		return 0, errors.New("missing i=")
+	}
+	return strconv.Atoi(strings.TrimSpace(tag[1]))
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Like a DKIM-Signature, but with i= as the instance and no v=
+func verifyAMS(r dns.Resolver, fields []Field, body []byte, set ARCSet) error {
+	p := set.Signature
+	if len(p.Diagnostics) > 0 {
+		return p.Diagnostics[0]
+	}
+	for tag, v := range map[string]string{"a": p.A, "b": p.B, "bh": p.BH, "d": p.D, "s": p.S} {
+		if v == "" {
+			return fmt.Errorf("missing required tag %s=", tag)
+		}
+	}
+	for _, h := range lower(p.H) {
+		if h == "arc-seal" {
+			return errors.New("h= includes ARC-Seal")
+		}
+	}
+
+	_, result, err := verifyMessageSignature(r, fields, body, set.AMS, p)
+	if result != dns.PASS {
+		return err
+	}
+	return nil
+}
+
+/*
+   The seal of the last set covers every set up to and including it, in instance order,
+   each as AAR, AMS then AS, relaxed canonicalization and no body.
+*/
+func verifyAS(r dns.Resolver, sets []ARCSet) error {
+	last := sets[len(sets)-1]
+	p := last.Seal
+	if len(p.Diagnostics) > 0 {
+		return p.Diagnostics[0]
+	}
+	if p.H != nil {
+		return errors.New("h= isn't allowed in an ARC-Seal")
+	}
+
+	var fields []Field
+	for _, set := range sets[:len(sets)-1] {
+		fields = append(fields, set.AAR, set.AMS, set.AS)
+	}
+	fields = append(fields, last.AAR, last.AMS)
+
+	var data []byte
+	for _, f := range fields {
+		data = append(data, canonicalHeader(f.Raw, "relaxed")...)
+	}
+	data = append(data, signedData(nil, nil, last.AS, "relaxed")...)
+
+	_, pub, hashType, result, err := signatureKey(r, p)
+	if result != dns.UNDEF {
+		return err
+	}
+	return verifyHash(pub, hashType, data, p.B)
+}
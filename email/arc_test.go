@@ -0,0 +1,98 @@
+package email
+
+import (
+    dns "bankrank/dns"
+    "crypto/ed25519"
+    "crypto/sha256"
+    b64 "encoding/base64"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+var arcKey = ed25519.NewKeyFromSeed([]byte("bankrank arc test key, 32 bytes."))
+
+var arcKeys, _ = dns.ParseZone(fmt.Sprintf(`
+arc._domainkey.lists.example.org.  IN TXT "v=DKIM1; k=ed25519; p=%s"
+arc._domainkey.relay.example.net.  IN TXT "v=DKIM1; k=ed25519; p=%s"
+`, b64.StdEncoding.EncodeToString(arcKey.Public().(ed25519.PublicKey)),
+   b64.StdEncoding.EncodeToString(arcKey.Public().(ed25519.PublicKey))))
+
+func arcSign(data []byte) string {
+    digest := sha256.Sum256(data)
+    return b64.StdEncoding.EncodeToString(ed25519.Sign(arcKey, digest[:]))
+}
+
+// Adds an ARC set to the top of message, as a forwarder at domain would
+func addARCSet(message string, instance int, domain string, cv string) string {
+    fields, body, _ := splitMessage([]byte(message))
+    sets, _ := collectARCSets(fields)
+
+    aar := Field{"ARC-Authentication-Results", fmt.Sprintf("ARC-Authentication-Results: i=%d; %s; dkim=pass header.d=football.example.com\r\n", instance, domain)}
+
+    bh := sha256.Sum256(canonicalBody(body, "relaxed"))
+    ams := Field{"ARC-Message-Signature", fmt.Sprintf("ARC-Message-Signature: i=%d; a=ed25519-sha256; c=relaxed/relaxed; d=%s; s=arc;\r\n h=from:to:subject:date; bh=%s; b=\r\n",
+                 instance, domain, b64.StdEncoding.EncodeToString(bh[:]))}
+    ams.Raw = strings.TrimSuffix(ams.Raw, "\r\n") + arcSign(signedData(fields, []string{"from", "to", "subject", "date"}, ams, "relaxed")) + "\r\n"
+
+    var data []byte
+    for _, set := range sets {
+        for _, f := range []Field{set.AAR, set.AMS, set.AS} {
+            data = append(data, canonicalHeader(f.Raw, "relaxed")...)
+        }
+    }
+    data = append(data, canonicalHeader(aar.Raw, "relaxed")...)
+    data = append(data, canonicalHeader(ams.Raw, "relaxed")...)
+    as := Field{"ARC-Seal", fmt.Sprintf("ARC-Seal: i=%d; a=ed25519-sha256; cv=%s; d=%s; s=arc; b=\r\n", instance, cv, domain)}
+    data = append(data, signedData(nil, nil, as, "relaxed")...)
+    as.Raw = strings.TrimSuffix(as.Raw, "\r\n") + arcSign(data) + "\r\n"
+
+    return as.Raw + ams.Raw + aar.Raw + string(toCRLF([]byte(message)))
+}
+
+func TestVerifyARC(t *testing.T) {
+    original := rsaSignature + footballMessage
+    hop1 := addARCSet(original, 1, "lists.example.org", "none")
+    //the list adds a footer, breaking the original DKIM signature and the first AMS
+    forwarded := hop1 + "-- \r\nThe football list\r\n"
+    hop2 := addARCSet(forwarded, 2, "relay.example.net", "pass")
+
+    var VerifyARCTests = []struct {
+        name    string
+        message string
+
+        result     int
+        oldestPass int
+    }{
+        {"unsealed", original, dns.NONE, 0},
+        {"one hop", hop1, dns.PASS, 0},
+        {"two hops", addARCSet(hop1, 2, "relay.example.net", "pass"), dns.PASS, 0},
+        {"modified between hops", hop2, dns.PASS, 2},
+        {"modified after sealing", hop1 + "-- \r\nfooter\r\n", dns.FAIL, 0},
+        {"tampered results", strings.Replace(hop2, "i=1; lists.example.org; dkim=pass", "i=1; lists.example.org; dkim=fail", 1), dns.FAIL, 2},
+        {"sealed as failed", addARCSet(forwarded, 2, "relay.example.net", "fail"), dns.FAIL, 0},
+        {"wrong cv", addARCSet(forwarded, 2, "relay.example.net", "none"), dns.FAIL, 0},
+        {"missing instance", addARCSet(original, 2, "relay.example.net", "pass"), dns.FAIL, 0},
+        {"duplicate instance", addARCSet(hop1, 1, "relay.example.net", "none"), dns.FAIL, 0},
+    }
+
+    for _, tt := range VerifyARCTests {
+        res, err := VerifyARC(arcKeys, []byte(tt.message))
+        if err != nil || res.Result != tt.result || res.OldestPass != tt.oldestPass {
+            t.Errorf("VerifyARC for %s was %s, oldest pass %d (%v) \n want %s, oldest pass %d\n", tt.name,
+                     dns.SPFResults[res.Result], res.OldestPass, res.Err, dns.SPFResults[tt.result], tt.oldestPass)
+        }
+    }
+
+    //the sets are reported whatever the result
+    res, _ := VerifyARC(arcKeys, []byte(hop2))
+    if len(res.Sets) != 2 || res.Sets[0].CV != "none" || res.Sets[1].Signature.D != "relay.example.net" ||
+       len(res.Sets[0].Results.Get("dkim")) != 1 {
+        t.Errorf("ARC sets were %+v\n", res.Sets)
+    }
+
+    //while the original signature no longer verifies
+    if d, _ := VerifyDKIM(dkimKeys, []byte(hop2)); d[0].Result != dns.FAIL {
+        t.Errorf("DKIM for a modified message was %s\n", dns.SPFResults[d[0].Result])
+    }
+}
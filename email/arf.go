@@ -0,0 +1,194 @@
+package email
+
+import (
+	"bytes"
+	b64 "encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"strings"
+)
+
+/*
+   An Abuse Reporting Format report, see https://tools.ietf.org/html/rfc5965,
+   with the authentication failure fields of https://tools.ietf.org/html/rfc6591 used by DMARC ruf= reports.
+*/
+type FeedbackReport struct {
+	Description string //the human readable first part
+
+	FeedbackType     string //"auth-failure" for DMARC
+	UserAgent        string
+	Version          string
+	SourceIP         net.IP
+	ArrivalDate      string
+	OriginalMailFrom string
+	OriginalRcptTo   []string
+	ReportedDomain   string
+
+	AuthFailure           string //"dkim", "spf", "dmarc", "bodyhash", "revoked" or "signature"
+	DeliveryResult        string
+	IdentityAlignment     string //"none", "spf", "dkim" or "dkim,spf"
+	DKIMDomain            string
+	DKIMSelector          string
+	DKIMIdentity          string
+	SPFDNS                string
+	AuthenticationResults []string
+
+	Fields []Field //the whole message/feedback-report part
+
+	//the reported message, which may only be its header (text/rfc822-headers)
+	Original   *Message
+	Signatures []*DKIMSigProfile
+}
+
+func ParseFeedbackReport(raw []byte) (*FeedbackReport, error) {
+	m, err := ParseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Type: %s", err)
+	}
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "feedback-report") {
+		return nil, fmt.Errorf("%s is not a feedback report", mediaType)
+	}
+
+	f := FeedbackReport{}
+	found := false
+
+	mr := multipart.NewReader(bytes.NewReader(m.Body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			if body, err = b64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), "")); err != nil {
+				return nil, err
+			}
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/feedback-report":
+			if err := f.parseFields(body); err != nil {
+				return nil, err
+			}
+			found = true
+		case "message/rfc822", "text/rfc822-headers":
+			if f.Original, err = ParseMessage(body); err != nil {
+				return nil, fmt.Errorf("reported message: %s", err)
+			}
+			f.Signatures = f.Original.DKIMSignatures()
+		case "text/plain", "":
+			if f.Description == "" {
+				f.Description = strings.TrimSpace(string(body))
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("report has no message/feedback-report part")
+	}
+	return &f, nil
+}
+
+// The machine readable part is a block of header fields
+func (f *FeedbackReport) parseFields(body []byte) error {
+	fields, _, err := splitMessage(bytes.TrimLeft(body, "\r\n"))
+	if err != nil {
+		return fmt.Errorf("feedback report: %s", err)
+	}
+	f.Fields = fields
+
+	for _, field := range fields {
+		v := field.Value()
+		switch strings.ToLower(field.Name) {
+		case "feedback-type":
+			f.FeedbackType = strings.ToLower(v)
+		case "user-agent":
+			f.UserAgent = v
+		case "version":
+			f.Version = v
+		case "source-ip":
+			f.SourceIP = net.ParseIP(strings.Trim(v, "[]"))
+		case "arrival-date", "received-date":
+			f.ArrivalDate = v
+		case "original-mail-from":
+			f.OriginalMailFrom = strings.Trim(v, "<>")
+		case "original-rcpt-to":
+			f.OriginalRcptTo = append(f.OriginalRcptTo, strings.Trim(v, "<>"))
+		case "reported-domain":
+			f.ReportedDomain = v
+		case "auth-failure":
+			f.AuthFailure = strings.ToLower(v)
+		case "delivery-result":
+			f.DeliveryResult = strings.ToLower(v)
+		case "identity-alignment":
+			f.IdentityAlignment = strings.ToLower(v)
+		case "dkim-domain":
+			f.DKIMDomain = v
+		case "dkim-selector":
+			f.DKIMSelector = v
+		case "dkim-identity":
+			f.DKIMIdentity = v
+		case "spf-dns":
+			f.SPFDNS = v
+		case "authentication-results":
+			f.AuthenticationResults = append(f.AuthenticationResults, v)
+		}
+	}
+
+	if f.FeedbackType == "" {
+		return fmt.Errorf("feedback report has no Feedback-Type")
+	}
+	return nil
+}
+
+var authFailures = map[string]string{
+	"bodyhash":  "the body hash didn't verify, the message was modified in transit",
+	"revoked":   "the DKIM key has been revoked",
+	"signature": "the DKIM signature didn't verify",
+	"spf":       "SPF failed",
+	"dkim":      "DKIM failed",
+	"dmarc":     "neither SPF nor DKIM passed for an aligned domain",
+}
+
+// Explains why the reported message failed, from the report and the original message's signatures
+func (f *FeedbackReport) Failures() (failures []string) {
+	if reason, ok := authFailures[f.AuthFailure]; ok {
+		failures = append(failures, reason)
+	}
+	if f.IdentityAlignment == "none" {
+		failures = append(failures, "no identity was aligned with the From domain")
+	}
+
+	if f.Original != nil && len(f.Signatures) == 0 {
+		failures = append(failures, "the message had no DKIM-Signature")
+	}
+	for _, sig := range f.Signatures {
+		for _, d := range sig.Diagnostics {
+			failures = append(failures, fmt.Sprintf("DKIM-Signature d=%s s=%s: %s", sig.D, sig.S, d))
+		}
+		if f.DKIMDomain != "" && !strings.EqualFold(sig.D, f.DKIMDomain) {
+			continue
+		}
+		if err := checkDKIMSig(sig); err != nil && len(sig.Diagnostics) == 0 {
+			failures = append(failures, fmt.Sprintf("DKIM-Signature d=%s s=%s: %s", sig.D, sig.S, err))
+		}
+	}
+
+	return
+}
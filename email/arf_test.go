@@ -0,0 +1,97 @@
+package email
+
+import (
+    "net"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// Based on the example in https://tools.ietf.org/html/rfc6591 appendix B
+const feedbackReport = `From: dmarc-reports@shopping.example.net
+To: dmarc-ruf@football.example.com
+Subject: FW: Is dinner ready?
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report;
+     boundary="part1_13d.2e68ed54_boundary"
+
+--part1_13d.2e68ed54_boundary
+Content-Type: text/plain; charset="US-ASCII"
+Content-Transfer-Encoding: 7bit
+
+This is an authentication failure report for an email message received from IP
+192.0.2.1 on Fri, 11 Jul 2003 21:01:00 -0700.
+
+--part1_13d.2e68ed54_boundary
+Content-Type: message/feedback-report
+
+Feedback-Type: auth-failure
+User-Agent: SomeGenerator/1.0
+Version: 1
+Original-Mail-From: <joe@football.example.com>
+Original-Rcpt-To: <suzie@shopping.example.net>
+Arrival-Date: Fri, 11 Jul 2003 21:01:00 -0700
+Source-IP: 192.0.2.1
+Reported-Domain: football.example.com
+Authentication-Results: mx.shopping.example.net;
+  dkim=fail header.d=football.example.com
+Auth-Failure: bodyhash
+DKIM-Domain: football.example.com
+DKIM-Selector: test
+Identity-Alignment: none
+
+--part1_13d.2e68ed54_boundary
+Content-Type: message/rfc822
+
+` + rsaSignature + footballMessage + `
+--part1_13d.2e68ed54_boundary--
+`
+
+func TestParseFeedbackReport(t *testing.T) {
+    f, err := ParseFeedbackReport([]byte(feedbackReport))
+    if err != nil {
+        t.Fatalf("ParseFeedbackReport returned %v", err)
+    }
+
+    if f.FeedbackType != "auth-failure" || f.AuthFailure != "bodyhash" || !f.SourceIP.Equal(net.ParseIP("192.0.2.1")) ||
+       f.OriginalMailFrom != "joe@football.example.com" || !reflect.DeepEqual(f.OriginalRcptTo, []string{"suzie@shopping.example.net"}) ||
+       f.DKIMDomain != "football.example.com" || f.DKIMSelector != "test" || f.IdentityAlignment != "none" {
+        t.Errorf("FeedbackReport was %+v\n", f)
+    }
+    if !reflect.DeepEqual(f.AuthenticationResults, []string{"mx.shopping.example.net;  dkim=fail header.d=football.example.com"}) {
+        t.Errorf("Authentication-Results was %q\n", f.AuthenticationResults)
+    }
+    if !strings.HasPrefix(f.Description, "This is an authentication failure report") {
+        t.Errorf("Description was %q\n", f.Description)
+    }
+    if f.Original.Get("Subject") != "Is dinner ready?" || len(f.Signatures) != 1 || f.Signatures[0].S != "test" {
+        t.Errorf("Original message was %+v with signatures %+v\n", f.Original, f.Signatures)
+    }
+
+    want := []string{"the body hash didn't verify, the message was modified in transit",
+                     "no identity was aligned with the From domain"}
+    if failures := f.Failures(); !reflect.DeepEqual(failures, want) {
+        t.Errorf("Failures were %q \n want %q\n", failures, want)
+    }
+}
+
+func TestParseFeedbackReportErrors(t *testing.T) {
+    if _, err := ParseFeedbackReport([]byte(footballMessage)); err == nil {
+        t.Errorf("ParseFeedbackReport accepted a plain message\n")
+    }
+
+    noReport := strings.Replace(feedbackReport, "message/feedback-report", "text/plain", 1)
+    if _, err := ParseFeedbackReport([]byte(noReport)); err == nil {
+        t.Errorf("ParseFeedbackReport accepted a report without a feedback-report part\n")
+    }
+
+    //a signature missing its selector is explained from the original message
+    broken := strings.Replace(feedbackReport, " s=test;", "", 1)
+    f, err := ParseFeedbackReport([]byte(broken))
+    if err != nil {
+        t.Fatalf("ParseFeedbackReport returned %v", err)
+    }
+    if failures := f.Failures(); len(failures) != 3 || !strings.Contains(failures[2], "missing required tag s=") {
+        t.Errorf("Failures were %q\n", failures)
+    }
+}
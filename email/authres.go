@@ -0,0 +1,275 @@
+package email
+
+import (
+	dns "bankrank/dns"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An Authentication-Results header, see https://tools.ietf.org/html/rfc8601
+type AuthResults struct {
+	ServID  string //the host which did the checks
+	Version string
+	Results []AuthResult
+}
+
+// One method's result, e.g. "dkim=pass header.d=example.com header.s=s1"
+type AuthResult struct {
+	Method  string //"spf", "dkim", "dmarc", "arc" etc.
+	Version string
+	Result  string
+	Reason  string
+	Props   map[string]string //e.g. "header.d", "smtp.mailfrom"
+}
+
+func ParseAuthResults(v string) (*AuthResults, error) {
+	v, err := stripComments(v)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := splitUnquoted(v, ';')
+	head := strings.Fields(segments[0])
+	if len(head) == 0 || len(head) > 2 {
+		return nil, fmt.Errorf("invalid authserv-id %q", strings.TrimSpace(segments[0]))
+	}
+
+	a := AuthResults{ServID: head[0]}
+	if len(head) == 2 {
+		a.Version = head[1]
+	}
+
+	for _, segment := range segments[1:] {
+		tokens := authResTokens(segment)
+		if len(tokens) == 0 || (len(tokens) == 1 && strings.EqualFold(tokens[0], "none")) {
+			continue
+		}
+
+		if len(tokens) < 3 || tokens[1] != "=" {
+			return nil, fmt.Errorf("invalid result %q", strings.TrimSpace(segment))
+		}
+		r := AuthResult{Result: strings.ToLower(tokens[2]), Props: make(map[string]string)}
+		r.Method = strings.ToLower(tokens[0])
+		if i := strings.Index(r.Method, "/"); i != -1 {
+			r.Method, r.Version = r.Method[:i], r.Method[i+1:]
+		}
+
+		for i := 3; i < len(tokens); i += 3 {
+			if i+2 >= len(tokens) || tokens[i+1] != "=" {
+				return nil, fmt.Errorf("invalid property in %q", strings.TrimSpace(segment))
+			}
+			key := strings.ToLower(tokens[i])
+			if key == "reason" {
+				r.Reason = tokens[i+2]
+			} else {
+				r.Props[key] = tokens[i+2]
+			}
+		}
+
+		a.Results = append(a.Results, r)
+	}
+
+	return &a, nil
+}
+
+// Every result for method, e.g. one per DKIM signature
+func (a *AuthResults) Get(method string) (results []AuthResult) {
+	for _, r := range a.Results {
+		if strings.EqualFold(r.Method, method) {
+			results = append(results, r)
+		}
+	}
+	return
+}
+
+// Renders the value of an Authentication-Results header, one result per folded line
+func (a *AuthResults) String() string {
+	var b strings.Builder
+	b.WriteString(a.ServID)
+	if a.Version != "" {
+		b.WriteString(" " + a.Version)
+	}
+
+	if len(a.Results) == 0 {
+		b.WriteString("; none")
+		return b.String()
+	}
+
+	for _, r := range a.Results {
+		b.WriteString(";\r\n\t" + r.Method)
+		if r.Version != "" {
+			b.WriteString("/" + r.Version)
+		}
+		b.WriteString("=" + r.Result)
+		if r.Reason != "" {
+			b.WriteString(" reason=" + quoteValue(r.Reason))
+		}
+
+		keys := make([]string, 0, len(r.Props))
+		for k := range r.Props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString(" " + k + "=" + quoteValue(r.Props[k]))
+		}
+	}
+
+	return b.String()
+}
+
+/*
+   The Authentication-Results bankrank would stamp on a message, from its own verdicts.
+   dmarc may be nil if no DMARC evaluation was done.
+*/
+func NewAuthResults(servID string, p *MessageProfile, dmarc *dns.DMARCResult) *AuthResults {
+	a := AuthResults{ServID: servID}
+
+	if p.SPF != nil || p.SPFResult != dns.NONE {
+		r := AuthResult{Method: "spf", Result: dns.SPFResults[p.SPFResult], Props: make(map[string]string)}
+		if p.ReturnPath != "" {
+			r.Props["smtp.mailfrom"] = p.ReturnPath
+		} else if p.NullPath && p.HELO != "" {
+			r.Props["smtp.helo"] = p.HELO
+		}
+		a.Results = append(a.Results, r)
+	}
+
+	if len(p.DKIM) == 0 {
+		a.Results = append(a.Results, AuthResult{Method: "dkim", Result: "none", Props: make(map[string]string)})
+	}
+	for _, d := range p.DKIM {
+		r := AuthResult{Method: "dkim", Result: dns.SPFResults[d.Result], Props: make(map[string]string)}
+		if d.Err != nil {
+			r.Reason = d.Err.Error()
+		}
+		if d.Signature.D != "" {
+			r.Props["header.d"] = d.Signature.D
+		}
+		if d.Signature.S != "" {
+			r.Props["header.s"] = d.Signature.S
+		}
+		//enough of the signature to tell several apart, see https://tools.ietf.org/html/rfc6008
+		if b := d.Signature.B; b != "" {
+			if len(b) > 8 {
+				b = b[:8]
+			}
+			r.Props["header.b"] = b
+		}
+		a.Results = append(a.Results, r)
+	}
+
+	if dmarc != nil {
+		r := AuthResult{Method: "dmarc", Result: dns.SPFResults[dmarc.Result], Props: make(map[string]string)}
+		if from := p.From; from != "" {
+			r.Props["header.from"] = from[strings.LastIndex(from, "@")+1:]
+		}
+		a.Results = append(a.Results, r)
+	}
+
+	return &a
+}
+
+// Values which aren't a single token are sent as quoted-strings
+func quoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t;()\"\\=") {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// Removes (possibly nested) comments outside of quoted-strings
+func stripComments(v string) (string, error) {
+	var b strings.Builder
+	depth, quoted := 0, false
+
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0) && i+1 < len(v):
+			if depth == 0 {
+				b.WriteByte(c)
+				b.WriteByte(v[i+1])
+			}
+			i++
+		case quoted:
+			b.WriteByte(c)
+			if c == '"' {
+				quoted = false
+			}
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+			if depth == 0 {
+				//comments separate tokens
+				b.WriteByte(' ')
+			}
+		case depth > 0:
+		case c == '"':
+			quoted = true
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	if depth > 0 || quoted {
+		return "", fmt.Errorf("unterminated comment or quoted-string in %q", v)
+	}
+	return b.String(), nil
+}
+
+func splitUnquoted(v string, sep byte) (parts []string) {
+	quoted, start := false, 0
+	for i := 0; i < len(v); i++ {
+		switch {
+		case v[i] == '\\' && quoted:
+			i++
+		case v[i] == '"':
+			quoted = !quoted
+		case v[i] == sep && !quoted:
+			parts = append(parts, v[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, v[start:])
+}
+
+/*
+   Splits a resinfo into words, "=" and (unquoted) values.
+   A value runs to the next whitespace, as addresses like SRS ones may contain "=".
+*/
+func authResTokens(s string) (tokens []string) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '=':
+			tokens = append(tokens, "=")
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			tokens = append(tokens, b.String())
+			i++
+		default:
+			afterEquals := len(tokens) > 0 && tokens[len(tokens)-1] == "="
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t\r\n", rune(s[end])) && (afterEquals || s[end] != '=') {
+				end++
+			}
+			tokens = append(tokens, s[i:end])
+			i = end
+		}
+	}
+	return
+}
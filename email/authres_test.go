@@ -0,0 +1,87 @@
+package email
+
+import (
+    dns "bankrank/dns"
+    "reflect"
+    "testing"
+)
+
+var ParseAuthResultsTests = []struct {
+    header string
+
+    result AuthResults
+}{
+    {header: "example.org 1; none",
+     result: AuthResults{"example.org", "1", nil}},
+    // See https://tools.ietf.org/html/rfc8601 appendix B
+    {header: `example.com;
+                  spf=pass smtp.mailfrom=example.net`,
+     result: AuthResults{"example.com", "", []AuthResult{
+         {"spf", "", "pass", "", map[string]string{"smtp.mailfrom": "example.net"}}}}},
+    {header: `example.com;
+                  auth=pass (cram-md5) smtp.auth=sender@example.net;
+                  spf=pass smtp.mailfrom=example.net`,
+     result: AuthResults{"example.com", "", []AuthResult{
+         {"auth", "", "pass", "", map[string]string{"smtp.auth": "sender@example.net"}},
+         {"spf", "", "pass", "", map[string]string{"smtp.mailfrom": "example.net"}}}}},
+    {header: `example.com;
+              sender-id=fail header.from=example.com;
+              dkim=pass (good signature) header.d=example.com;
+              dkim/1 = fail reason="bad signature, wrong key"
+                   header.d=example.com header.s=s2 (old selector)`,
+     result: AuthResults{"example.com", "", []AuthResult{
+         {"sender-id", "", "fail", "", map[string]string{"header.from": "example.com"}},
+         {"dkim", "", "pass", "", map[string]string{"header.d": "example.com"}},
+         {"dkim", "1", "fail", "bad signature, wrong key", map[string]string{"header.d": "example.com", "header.s": "s2"}}}}},
+    //SRS addresses contain "="
+    {header: "mx.google.com; spf=pass (google.com: domain of SRS0=ab=cd=bank.com=joe@esp.com designates 192.0.2.1 as permitted sender) smtp.mailfrom=SRS0=ab=cd=bank.com=joe@esp.com; dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=bank.com; arc=none",
+     result: AuthResults{"mx.google.com", "", []AuthResult{
+         {"spf", "", "pass", "", map[string]string{"smtp.mailfrom": "SRS0=ab=cd=bank.com=joe@esp.com"}},
+         {"dmarc", "", "pass", "", map[string]string{"header.from": "bank.com"}},
+         {"arc", "", "none", "", map[string]string{}}}}},
+}
+
+func TestParseAuthResults(t *testing.T) {
+    for _, tt := range ParseAuthResultsTests {
+        a, err := ParseAuthResults(tt.header)
+        if err != nil || !reflect.DeepEqual(*a, tt.result) {
+            t.Errorf("AuthResults for %q was %+v (%v) \n want %+v\n", tt.header, a, err, tt.result)
+        }
+    }
+
+    for _, header := range []string{"", "example.com; spf", "example.com; spf=pass smtp.mailfrom", "example.com; spf=pass (unterminated"} {
+        if a, err := ParseAuthResults(header); err == nil {
+            t.Errorf("AuthResults for %q was %+v, want an error\n", header, a)
+        }
+    }
+}
+
+func TestNewAuthResults(t *testing.T) {
+    p, _ := ParseMessageProfile(dkimKeys, []byte(receivedHeaders + rsaSignature + footballMessage))
+    dmarc := &dns.DMARCResult{Result: dns.PASS}
+
+    a := NewAuthResults("mx.bankrank.example", p, dmarc)
+    want := "mx.bankrank.example;\r\n" +
+            "\tspf=pass smtp.mailfrom=joe@football.example.com;\r\n" +
+            "\tdkim=pass header.b=F45dVWDf header.d=football.example.com header.s=test;\r\n" +
+            "\tdmarc=pass header.from=football.example.com"
+    if s := a.String(); s != want {
+        t.Errorf("AuthResults was %q \n want %q\n", s, want)
+    }
+
+    //what we generate, we can parse
+    parsed, err := ParseAuthResults(a.String())
+    if err != nil || !reflect.DeepEqual(parsed, a) {
+        t.Errorf("AuthResults round trip was %+v (%v) \n want %+v\n", parsed, err, a)
+    }
+
+    a = NewAuthResults("mx.bankrank.example", &MessageProfile{SPFResult: dns.NONE}, nil)
+    if s := a.String(); s != "mx.bankrank.example;\r\n\tdkim=none" {
+        t.Errorf("AuthResults for an unsigned message was %q\n", s)
+    }
+
+    a.Results[0].Reason = `key "s1" not found`
+    if parsed, _ := ParseAuthResults(a.String()); parsed.Results[0].Reason != a.Results[0].Reason {
+        t.Errorf("AuthResults reason was %q after a round trip\n", parsed.Results[0].Reason)
+    }
+}
@@ -0,0 +1,425 @@
+package email
+
+import (
+	dns "bankrank/dns"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A header field as it appeared in the message.
+// Raw keeps the name, folding and trailing CRLF, which simple canonicalization needs.
+type Field struct {
+	Name string
+	Raw  string
+}
+
+// The unfolded value of the field, without the name and surrounding whitespace
+func (f Field) Value() string {
+	v := f.Raw[strings.Index(f.Raw, ":")+1:]
+	v = strings.Replace(v, "\r\n", "", -1)
+	return strings.TrimSpace(v)
+}
+
+// The outcome of verifying a single DKIM-Signature, see https://tools.ietf.org/html/rfc6376 section 6
+type DKIMResult struct {
+	Result    int //dns.PASS, dns.FAIL, dns.NEUTRAL, dns.TEMP_ERROR or dns.PERM_ERROR
+	Err       error
+	Signature *DKIMSigProfile
+	Key       *dns.DKIMDNSProfile
+	Findings  []int //from AuditDKIMSig
+	Coverage  []HeaderCoverage
+}
+
+/*
+   Splits a raw message into its header fields and body.
+   Bare LF line endings, as found in most .eml files, are converted to CRLF first.
+*/
+func splitMessage(raw []byte) (fields []Field, body []byte, err error) {
+	msg := toCRLF(raw)
+
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	var header []byte
+	switch {
+	case bytes.HasPrefix(msg, []byte("\r\n")):
+		body = msg[2:]
+	case end == -1:
+		header = msg
+	default:
+		header = msg[:end+2]
+		body = msg[end+4:]
+	}
+
+	for len(header) > 0 {
+		//a field continues for as long as the following lines start with whitespace
+		n := 0
+		for {
+			eol := bytes.Index(header[n:], []byte("\r\n"))
+			if eol == -1 {
+				n = len(header)
+				break
+			}
+			n += eol + 2
+			if n == len(header) || (header[n] != ' ' && header[n] != '\t') {
+				break
+			}
+		}
+
+		raw := string(header[:n])
+		header = header[n:]
+
+		colon := strings.Index(raw, ":")
+		if colon <= 0 {
+			return nil, nil, fmt.Errorf("malformed header line %q", strings.TrimSpace(raw))
+		}
+		if !strings.HasSuffix(raw, "\r\n") {
+			raw += "\r\n"
+		}
+		fields = append(fields, Field{strings.TrimRight(raw[:colon], " \t"), raw})
+	}
+
+	return
+}
+
+func toCRLF(b []byte) []byte {
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(b, []byte("\n"), []byte("\r\n"), -1)
+}
+
+/*
+   Verifies every DKIM-Signature in a raw message, in the order they appear.
+   A message without any signatures has no results.
+*/
+func VerifyDKIM(r dns.Resolver, message []byte) ([]DKIMResult, error) {
+	fields, body, err := splitMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DKIMResult
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, "DKIM-Signature") {
+			results = append(results, VerifyDKIMSignature(r, fields, body, f))
+		}
+	}
+	return results, nil
+}
+
+// Verifies one DKIM-Signature field against the other fields and body of its message
+func VerifyDKIMSignature(r dns.Resolver, fields []Field, body []byte, sig Field) (res DKIMResult) {
+	p := ParseDKIMSig(sig.Value())
+	res.Signature = p
+	m := &Message{fields, body}
+	res.Findings, res.Coverage = AuditDKIMSig(p, m), DKIMCoverage(p, m)
+
+	if err := checkDKIMSig(p); err != nil {
+		res.Result, res.Err = dns.PERM_ERROR, err
+		return res
+	}
+
+	res.Key, res.Result, res.Err = verifyMessageSignature(r, fields, body, sig, p)
+	return res
+}
+
+// Checks the body hash and b= of a DKIM-Signature or ARC-Message-Signature, whose tags have already been checked
+func verifyMessageSignature(r dns.Resolver, fields []Field, body []byte, sig Field, p *DKIMSigProfile) (*dns.DKIMDNSProfile, int, error) {
+	headerCanon, bodyCanon, err := parseCanonicalization(p.C)
+	if err != nil {
+		return nil, dns.PERM_ERROR, err
+	}
+
+	key, pub, hashType, result, err := signatureKey(r, p)
+	if result != dns.UNDEF {
+		return key, result, err
+	}
+	if _, err := b64.StdEncoding.DecodeString(p.B); err != nil {
+		return key, dns.PERM_ERROR, fmt.Errorf("b= is not valid base64: %s", err)
+	}
+
+	//body hash, over at most l= octets of the canonicalized body
+	cbody := canonicalBody(body, bodyCanon)
+	if p.L > 0 {
+		if p.L > int64(len(cbody)) {
+			return key, dns.PERM_ERROR, fmt.Errorf("l=%d is longer than the %d octet body", p.L, len(cbody))
+		}
+		cbody = cbody[:p.L]
+	}
+	h := newHash(hashType)
+	h.Write(cbody)
+	if b64.StdEncoding.EncodeToString(h.Sum(nil)) != p.BH {
+		return key, keyFailure(key), errors.New("body hash did not verify")
+	}
+
+	if err := verifyHash(pub, hashType, signedData(fields, p.H, sig, headerCanon), p.B); err != nil {
+		return key, keyFailure(key), err
+	}
+	return key, dns.PASS, nil
+}
+
+// Looks up and parses the key a signature was made with, result is UNDEF if it can be used
+func signatureKey(r dns.Resolver, p *DKIMSigProfile) (key *dns.DKIMDNSProfile, pub crypto.PublicKey, hashType crypto.Hash, result int, err error) {
+	keyType, hashType, err := parseAlgorithm(p.A)
+	if err != nil {
+		return nil, nil, 0, dns.PERM_ERROR, err
+	}
+
+	key, result, err = lookupDKIMKey(r, p)
+	if result != dns.UNDEF {
+		return key, nil, 0, result, err
+	}
+
+	if pub, err = parseDKIMKey(key, keyType, hashType); err != nil {
+		return key, nil, 0, dns.PERM_ERROR, err
+	}
+	return key, pub, hashType, dns.UNDEF, nil
+}
+
+// The canonicalized fields named in h=, followed by the signature field itself without the value of b=
+func signedData(fields []Field, h []string, sig Field, canon string) []byte {
+	data := signedHeaders(fields, h, canon)
+	return append(data, strings.TrimSuffix(canonicalHeader(stripSignature(sig.Raw), canon), "\r\n")...)
+}
+
+// Checks the base64 signature b over the hash of data
+func verifyHash(pub crypto.PublicKey, hashType crypto.Hash, data []byte, b string) error {
+	sigBytes, err := b64.StdEncoding.DecodeString(b)
+	if err != nil {
+		return fmt.Errorf("b= is not valid base64: %s", err)
+	}
+
+	h := newHash(hashType)
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, hashType, digest, sigBytes)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, sigBytes) {
+			err = errors.New("ed25519 verification failed")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("signature did not verify: %s", err)
+	}
+	return nil
+}
+
+// Signatures from a key in testing mode must be treated as if the message was unsigned
+func keyFailure(key *dns.DKIMDNSProfile) int {
+	if key.T["y"] {
+		return dns.NEUTRAL
+	}
+	return dns.FAIL
+}
+
+// Checks the tags which must be present and consistent before a verifier does any work
+func checkDKIMSig(p *DKIMSigProfile) error {
+	if errs := dkimSigErrors(p); len(errs) > 0 {
+		return errs[0].err
+	}
+	return nil
+}
+
+// Why checkDKIMSig rejects a signature, finding is the SIG_* AuditDKIMSig reports, or -1 if there isn't one
+type dkimSigError struct {
+	finding int
+	err     error
+}
+
+// Every reason checkDKIMSig has to reject a signature, in the order it checks them
+func dkimSigErrors(p *DKIMSigProfile) (errs []dkimSigError) {
+	if len(p.Diagnostics) > 0 {
+		errs = append(errs, dkimSigError{-1, p.Diagnostics[0]})
+	}
+	if p.V != 1 {
+		errs = append(errs, dkimSigError{SIG_BAD_VERSION, fmt.Errorf("unsupported version v=%d", p.V)})
+	}
+	for _, tag := range []struct{ name, v string }{{"a", p.A}, {"b", p.B}, {"bh", p.BH}, {"d", p.D}, {"s", p.S}} {
+		if tag.v == "" {
+			errs = append(errs, dkimSigError{-1, fmt.Errorf("missing required tag %s=", tag.name)})
+		}
+	}
+	if !contains(lower(p.H), "from") {
+		errs = append(errs, dkimSigError{SIG_FROM_UNSIGNED, errors.New("h= does not include From")})
+	}
+	if p.I != "" {
+		domain := strings.ToLower(p.I[strings.LastIndex(p.I, "@")+1:])
+		d := strings.ToLower(p.D)
+		if domain != d && !strings.HasSuffix(domain, "."+d) {
+			errs = append(errs, dkimSigError{SIG_IDENTITY_OUTSIDE_D, fmt.Errorf("i=%s is not within d=%s", p.I, p.D)})
+		}
+	}
+	if p.X > 0 && time.Now().After(time.Unix(p.X, 0)) {
+		errs = append(errs, dkimSigError{SIG_EXPIRED, fmt.Errorf("signature expired at %s", time.Unix(p.X, 0).UTC())})
+	}
+	return
+}
+
+func lower(arr []string) (r []string) {
+	for _, v := range arr {
+		r = append(r, strings.ToLower(strings.TrimSpace(v)))
+	}
+	return
+}
+
+// Fetches the key record, a missing key is a permanent error
+func lookupDKIMKey(r dns.Resolver, p *DKIMSigProfile) (*dns.DKIMDNSProfile, int, error) {
+	name := p.S + "._domainkey." + p.D
+	txts, err := r.LookupTXT(name)
+	if err != nil {
+		var dnsErr interface{ Temporary() bool }
+		if errors.As(err, &dnsErr) && dnsErr.Temporary() {
+			return nil, dns.TEMP_ERROR, err
+		}
+		return nil, dns.PERM_ERROR, fmt.Errorf("no key at %s: %s", name, err)
+	}
+
+	record, err := dns.DKIMKeyRecord(txts)
+	if err != nil {
+		return nil, dns.PERM_ERROR, fmt.Errorf("%s at %s", err, name)
+	}
+	return dns.ParseDKIMDNS(record), dns.UNDEF, nil
+}
+
+func parseDKIMKey(key *dns.DKIMDNSProfile, keyType string, hashType crypto.Hash) (crypto.PublicKey, error) {
+	if len(key.Diagnostics) > 0 {
+		return nil, key.Diagnostics[0]
+	}
+
+	k := dns.ParseDKIMKey(key)
+	if k.Type != keyType {
+		return nil, fmt.Errorf("k=%s key can't verify a %s signature", k.Type, keyType)
+	}
+	if !k.AllowsHash(map[crypto.Hash]string{crypto.SHA1: "sha1", crypto.SHA256: "sha256"}[hashType]) {
+		return nil, fmt.Errorf("key only allows h=%s", key.H)
+	}
+	if !k.ForEmail() {
+		return nil, fmt.Errorf("key is only for s=%s", key.S)
+	}
+	if k.Err != nil {
+		return nil, k.Err
+	}
+	return k.Public, nil
+}
+
+// a= is <key type>-<hash>
+func parseAlgorithm(a string) (keyType string, hashType crypto.Hash, err error) {
+	switch strings.ToLower(a) {
+	case "rsa-sha256":
+		return "rsa", crypto.SHA256, nil
+	case "rsa-sha1":
+		return "rsa", crypto.SHA1, nil
+	case "ed25519-sha256":
+		return "ed25519", crypto.SHA256, nil
+	}
+	return "", 0, fmt.Errorf("unsupported algorithm a=%s", a)
+}
+
+func newHash(h crypto.Hash) hash.Hash {
+	if h == crypto.SHA1 {
+		return sha1.New()
+	}
+	return sha256.New()
+}
+
+// c= is <header>/<body>, a missing body algorithm is simple
+func parseCanonicalization(c string) (header string, body string, err error) {
+	if c == "" {
+		c = "simple/simple"
+	}
+
+	split := strings.SplitN(strings.ToLower(c), "/", 2)
+	header, body = split[0], "simple"
+	if len(split) == 2 {
+		body = split[1]
+	}
+
+	for _, v := range []string{header, body} {
+		if v != "simple" && v != "relaxed" {
+			return "", "", fmt.Errorf("unsupported canonicalization c=%s", c)
+		}
+	}
+	return
+}
+
+var wsp = regexp.MustCompile("[ \t]+")
+
+// See https://tools.ietf.org/html/rfc6376 section 3.4.1 and 3.4.2
+func canonicalHeader(raw string, canon string) string {
+	if canon == "simple" {
+		return raw
+	}
+
+	colon := strings.Index(raw, ":")
+	name := strings.ToLower(strings.TrimRight(raw[:colon], " \t"))
+	value := strings.Replace(raw[colon+1:], "\r\n", "", -1)
+	value = strings.TrimSpace(wsp.ReplaceAllString(value, " "))
+
+	return name + ":" + value + "\r\n"
+}
+
+// See https://tools.ietf.org/html/rfc6376 section 3.4.3 and 3.4.4
+func canonicalBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(toCRLF(body)), "\r\n")
+
+	if canon == "relaxed" {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(wsp.ReplaceAllString(l, " "), " ")
+		}
+	}
+
+	//ignore all empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if canon == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+/*
+   Selects the header fields named in h=, in order. Repeated names select instances from the bottom up,
+   and names without a (remaining) instance contribute nothing, which is how over-signing works.
+*/
+func signedHeaders(fields []Field, h []string, canon string) []byte {
+	used := make(map[int]bool)
+	var b bytes.Buffer
+
+	for _, name := range h {
+		name = strings.TrimSpace(name)
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].Name, name) {
+				used[i] = true
+				b.WriteString(canonicalHeader(fields[i].Raw, canon))
+				break
+			}
+		}
+	}
+
+	return b.Bytes()
+}
+
+var sigValue = regexp.MustCompile(`((?:^|;)[ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+
+// The signature field is hashed with the value of b= removed
+func stripSignature(raw string) string {
+	colon := strings.Index(raw, ":")
+	value := sigValue.ReplaceAllString(strings.TrimSuffix(raw[colon+1:], "\r\n"), "$1")
+	return raw[:colon+1] + value + "\r\n"
+}
@@ -0,0 +1,124 @@
+package email
+
+import (
+    dns "bankrank/dns"
+    "strings"
+    "testing"
+)
+
+// The example message and keys from https://tools.ietf.org/html/rfc8463 appendix A
+var dkimKeys, _ = dns.ParseZone(`
+brisbane._domainkey.football.example.com. IN TXT "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+test._domainkey.football.example.com.     IN TXT "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDkHlOQoBTzWRiGs5V6NpP3idY6Wk08a5qhdR6wy5bdOKb2jLQiY/J16JYi0Qvx/byYzCNb3W91y3FutACDfzwQ/BC/e/8uBsCR+yz1Lxj+PL6lHvqMKrM3rG4hstT5QjvHO9PzoxZyVYLzBfO2EeC3Ip3G+2kryOTIKT+l/K4w3QIDAQAB"
+brisbane._domainkey.testing.example.com.  IN TXT "v=DKIM1; k=ed25519; t=y; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+fixture._domainkey.football.example.com.  IN TXT "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDHrZZ5hUV31waouu8VusrSeXALSZUFr9/6YYE7afbOsas2ugbLow4wbjGfjpTLue1uHqJ9/PEtOIdpcNhfGXaK6KVEDapynZLomi+nHWs+u88aULoEI8kTUiapHBKtnz0TTo/s50jrWN/4h6lFxM58K63e+M+p9unN9bCNZn/xGQIDAQAB"
+test._domainkey.revoked.example.com.      IN TXT "v=DKIM1; k=rsa; p="
+test._domainkey.twice.example.com.        IN TXT "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDkHlOQoBTzWRiGs5V6NpP3idY6Wk08a5qhdR6wy5bdOKb2jLQiY/J16JYi0Qvx/byYzCNb3W91y3FutACDfzwQ/BC/e/8uBsCR+yz1Lxj+PL6lHvqMKrM3rG4hstT5QjvHO9PzoxZyVYLzBfO2EeC3Ip3G+2kryOTIKT+l/K4w3QIDAQAB"
+test._domainkey.twice.example.com.        IN TXT "v=DKIM1; k=rsa; p="
+football.example.com.                     IN TXT "v=spf1 ip4:192.0.2.0/24 -all"
+`)
+
+const ed25519Signature = `DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+`
+
+const rsaSignature = `DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=test; t=1528637909; h=from : to : subject :
+ date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=F45dVWDfMbQDGHJFlXUNB2HKfbCeLRyhDXgFpEL8GwpsRe0IeIixNTe3
+ DhCVlUrSjV4BwcVcOF6+FF3Zo9Rpo1tFOeS9mPYQTnGdaSGsgeefOsk2Jz
+ dA+L10TeYt9BgDfQNZtKdN1WO//KgIqXP7OdEFE4LjFYNcUxZQ4FADY+8=
+`
+
+const footballMessage = `From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`
+
+var VerifyDKIMTests = []struct {
+    message string
+
+    result int
+}{
+    {message: ed25519Signature + footballMessage, result: dns.PASS},
+    {message: rsaSignature + footballMessage, result: dns.PASS},
+    //trailing blank lines and whitespace are canonicalized away by relaxed/relaxed
+    {message: rsaSignature + footballMessage + "\n\n", result: dns.PASS},
+    {message: rsaSignature + strings.Replace(footballMessage, "hungry yet?", "hungry yet?  ", 1), result: dns.PASS},
+    {message: rsaSignature + strings.Replace(footballMessage, "lost", "won", 1), result: dns.FAIL},
+    {message: rsaSignature + strings.Replace(footballMessage, "dinner", "lunch", 1), result: dns.FAIL},
+    //the from header is over-signed, so adding another breaks the signature
+    {message: rsaSignature + "From: Mallory <mallory@example.org>\n" + footballMessage, result: dns.FAIL},
+    {message: strings.Replace(ed25519Signature, "football.example.com;", "testing.example.com;", -1) + footballMessage, result: dns.NEUTRAL},
+    {message: strings.Replace(rsaSignature, "football.example.com;", "revoked.example.com;", -1) + footballMessage, result: dns.PERM_ERROR},
+    //one key record each is required, even if one of them would verify
+    {message: strings.Replace(rsaSignature, "football.example.com;", "twice.example.com;", -1) + footballMessage, result: dns.PERM_ERROR},
+    {message: strings.Replace(rsaSignature, "s=test", "s=missing", 1) + footballMessage, result: dns.PERM_ERROR},
+    {message: strings.Replace(rsaSignature, "a=rsa-sha256", "a=rsa-md5", 1) + footballMessage, result: dns.PERM_ERROR},
+    {message: strings.Replace(rsaSignature, "s=test", "s=brisbane", 1) + footballMessage, result: dns.PERM_ERROR},
+    {message: strings.Replace(strings.Replace(rsaSignature, "from : ", "", -1), "h=", "h=to : ", 1) + footballMessage, result: dns.PERM_ERROR},
+}
+
+func TestVerifyDKIM(t *testing.T) {
+    for _, tt := range VerifyDKIMTests {
+        results, err := VerifyDKIM(dkimKeys, []byte(tt.message))
+        if err != nil || len(results) != 1 {
+            t.Errorf("VerifyDKIM for %q returned %d results, %v\n", tt.message, len(results), err)
+            continue
+        }
+        if results[0].Result != tt.result {
+            t.Errorf("VerifyDKIM for %q was %s (%v)\n want %s\n", tt.message,
+                     dns.SPFResults[results[0].Result], results[0].Err, dns.SPFResults[tt.result])
+        }
+    }
+}
+
+var CanonicalBodyTests = []struct {
+    body  string
+    canon string
+
+    result string
+}{
+    // See https://tools.ietf.org/html/rfc6376 section 3.4.5
+    {body: " C \r\nD \t E\r\n\r\n\r\n", canon: "relaxed", result: " C\r\nD E\r\n"},
+    {body: " C \r\nD \t E\r\n\r\n\r\n", canon: "simple", result: " C \r\nD \t E\r\n"},
+    {body: "", canon: "simple", result: "\r\n"},
+    {body: "", canon: "relaxed", result: ""},
+}
+
+func TestCanonicalBody(t *testing.T) {
+    for _, tt := range CanonicalBodyTests {
+        if b := string(canonicalBody([]byte(tt.body), tt.canon)); b != tt.result {
+            t.Errorf("%s body for %q was %q \n want %q\n", tt.canon, tt.body, b, tt.result)
+        }
+    }
+}
+
+func TestCanonicalHeader(t *testing.T) {
+    raw := "SubJect : AbC\r\n"
+    if h := canonicalHeader(raw, "relaxed"); h != "subject:AbC\r\n" {
+        t.Errorf("relaxed header for %q was %q\n", raw, h)
+    }
+    raw = "A: X\r\n\t Y Z  \r\n"
+    if h := canonicalHeader(raw, "relaxed"); h != "a:X Y Z\r\n" {
+        t.Errorf("relaxed header for %q was %q\n", raw, h)
+    }
+    if h := canonicalHeader(raw, "simple"); h != raw {
+        t.Errorf("simple header for %q was %q\n", raw, h)
+    }
+}
@@ -1,10 +1,11 @@
 package email
 
 import (
+	dns "bankrank/dns"
 	"fmt"
 	reflections "github.com/oleiade/reflections"
-	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -32,13 +33,8 @@ type DKIMSigProfile struct {
 	L int64
 	Q string
 	Z map[string]string
-}
 
-func handleError(err error) {
-	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(1)
-	}
+	Diagnostics []dns.Diagnostic
 }
 
 // Parses parameters of the format "xxx(=xxxx)?"
@@ -64,11 +60,8 @@ func parseParams(v string) (params map[string]string) {
 	return
 }
 
-func parseInt(str string) (r int64) {
-	r, err := strconv.ParseInt(str, 10, 64)
-	handleError(err)
-
-	return
+func parseInt(str string) (int64, error) {
+	return strconv.ParseInt(str, 10, 64)
 }
 
 func contains(arr []string, str string) bool {
@@ -91,25 +84,204 @@ func contains(arr []string, str string) bool {
    L : value MUST NOT be larger than the actual number of octets in the canonicalized message body.
    I : The domain part of the (email) address MUST be the same as, or a subdomain of, the value of the "d=" tag.
 */
-func ScoreDKIMSig(p *DKIMSigProfile) (score int) {
+func ScoreDKIMSig(p *DKIMSigProfile) int {
+	return scoreDKIMSig(p, AuditDKIMSig(p, nil), DKIMCoverage(p, nil))
+}
+
+// What can be wrong with the tags of a DKIM-Signature
+const (
+	SIG_BAD_VERSION        = iota //0, v= isn't 1
+	SIG_SIGNS_ITSELF              //1, h= includes DKIM-Signature
+	SIG_FROM_UNSIGNED             //2, h= doesn't include From
+	SIG_IDENTITY_OUTSIDE_D        //3, i= isn't d= or a subdomain of it
+	SIG_FUTURE_TIMESTAMP          //4
+	SIG_EXPIRES_BEFORE_SIGNED     //5, x= isn't after t=
+	SIG_EXPIRED                   //6
+	SIG_UNKNOWN_QUERY             //7, q= isn't dns/txt
+	SIG_BODY_LENGTH               //8, l= allows content to be appended to the body
+	SIG_BODY_LENGTH_EXCEEDED      //9, l= is longer than the body
+	SIG_UNSIGNED_CONTENT          //10, the body does have content after l=
+	SIG_INJECTABLE_HEADERS        //11, a critical header isn't over-signed, so another instance can be added
+	SIG_DUPLICATE_HEADERS         //12, the message has several instances of a critical header
+)
+
+var DKIMSigFindings = map[int]string{
+	SIG_BAD_VERSION:           "unsupported version",
+	SIG_SIGNS_ITSELF:          "signs itself",
+	SIG_FROM_UNSIGNED:         "From is not signed",
+	SIG_IDENTITY_OUTSIDE_D:    "identity outside signing domain",
+	SIG_FUTURE_TIMESTAMP:      "timestamp in the future",
+	SIG_EXPIRES_BEFORE_SIGNED: "expires before it was signed",
+	SIG_EXPIRED:               "expired",
+	SIG_UNKNOWN_QUERY:         "unknown query method",
+	SIG_BODY_LENGTH:           "body length limit",
+	SIG_BODY_LENGTH_EXCEEDED:  "body length limit exceeds body",
+	SIG_UNSIGNED_CONTENT:      "unsigned body content",
+	SIG_INJECTABLE_HEADERS:    "headers open to injection",
+	SIG_DUPLICATE_HEADERS:     "duplicated headers",
+}
+
+// How many points each finding costs, the others mean the signature can't be relied upon at all
+var dkimSigPenalties = map[int]int{
+	SIG_BODY_LENGTH:      2,
+	SIG_UNSIGNED_CONTENT: 2,
+	//already reflected in the coverage score
+	SIG_INJECTABLE_HEADERS: 0,
+}
+
+/*
+   Checks a signature's tags against RFC 6376 section 3.5.
+   m is the signed message, if there is one, to check l= against the canonicalized body.
+*/
+func AuditDKIMSig(p *DKIMSigProfile, m *Message) (findings []int) {
+	//whatever a verifier would reject the signature for
+	for _, e := range dkimSigErrors(p) {
+		if e.finding >= 0 {
+			findings = append(findings, e.finding)
+		}
+	}
+
+	if contains(lower(p.H), "dkim-signature") {
+		findings = append(findings, SIG_SIGNS_ITSELF)
+	}
+	if p.T > 0 && time.Now().Before(time.Unix(p.T, 0)) {
+		findings = append(findings, SIG_FUTURE_TIMESTAMP)
+	}
+	if p.X > 0 && p.T > 0 && p.X <= p.T {
+		findings = append(findings, SIG_EXPIRES_BEFORE_SIGNED)
+	}
+
+	//See https://tools.ietf.org/html/rfc6376 section 3.5, dns/txt is the only method defined
+	if p.Q != "" {
+		for _, q := range strings.Split(strings.ToLower(p.Q), ":") {
+			if q != "dns/txt" && q != "dns" {
+				findings = append(findings, SIG_UNKNOWN_QUERY)
+				break
+			}
+		}
+	}
+
+	if p.L > 0 {
+		//See https://tools.ietf.org/html/rfc6376 section 8.2
+		findings = append(findings, SIG_BODY_LENGTH)
+		if m != nil {
+			_, bodyCanon, err := parseCanonicalization(p.C)
+			if err == nil {
+				length := int64(len(canonicalBody(m.Body, bodyCanon)))
+				switch {
+				case p.L > length:
+					findings = append(findings, SIG_BODY_LENGTH_EXCEEDED)
+				case p.L < length:
+					findings = append(findings, SIG_UNSIGNED_CONTENT)
+				}
+			}
+		}
+	}
+
+	injectable, duplicated := false, false
+	for _, c := range DKIMCoverage(p, m) {
+		injectable = injectable || !c.OverSigned()
+		duplicated = duplicated || c.Instances > 1
+	}
+	if injectable {
+		findings = append(findings, SIG_INJECTABLE_HEADERS)
+	}
+	if duplicated {
+		findings = append(findings, SIG_DUPLICATE_HEADERS)
+	}
+
+	sort.Ints(findings)
+	return
+}
+
+// The fields an attacker would most like to add a second instance of, which MUAs may display instead of the signed one
+var CriticalHeaders = []string{"from", "subject", "reply-to", "to", "date", "content-type"}
+
+// How well a signature protects one header field
+type HeaderCoverage struct {
+	Name      string
+	Instances int //in the message
+	Signed    int //times h= names it
+}
+
+/*
+   Naming a field in h= more times than it appears signs its absence too, see https://tools.ietf.org/html/rfc6376 section 8.15,
+   so that adding another instance breaks the signature.
+*/
+func (c HeaderCoverage) OverSigned() bool {
+	return c.Signed > c.Instances
+}
+
+/*
+   How the CriticalHeaders are covered by a signature of m.
+   Without the message each signed field is assumed to appear once.
+*/
+func DKIMCoverage(p *DKIMSigProfile, m *Message) (coverage []HeaderCoverage) {
+	h := lower(p.H)
+
+	for _, name := range CriticalHeaders {
+		c := HeaderCoverage{Name: name}
+		for _, v := range h {
+			if v == name {
+				c.Signed++
+			}
+		}
+
+		if m != nil {
+			for _, f := range m.Fields {
+				if strings.EqualFold(f.Name, name) {
+					c.Instances++
+				}
+			}
+		} else if c.Signed > 0 {
+			c.Instances = 1
+		}
+
+		coverage = append(coverage, c)
+	}
+
+	return
+}
+
+func scoreDKIMSig(p *DKIMSigProfile, findings []int, coverage []HeaderCoverage) (score int) {
 	score = 0
 
-	//TODO: L, I considerations as above
-	if p.V != 1 || contains(p.H, "DKIM-Signature") || time.Now().Before(time.Unix(p.T, 0)) ||
-		time.Unix(p.X, 0).Before(time.Unix(p.T, 0)) {
+	//a signature with malformed tags can't be relied upon
+	if len(p.Diagnostics) > 0 {
 		return 0
 	}
 
+	penalty := 0
+	for _, f := range findings {
+		cost, ok := dkimSigPenalties[f]
+		if !ok {
+			return 0
+		}
+		penalty += cost
+	}
+
 	if p.A == "rsa-sha256" {
 		score += 2
 	} else {
 		score += 1
 	}
 
-	score += len(p.H)
+	//a point for each critical header signed, and another for over-signing it
+	for _, c := range coverage {
+		if c.Signed > 0 {
+			score += 1
+		}
+		if c.OverSigned() {
+			score += 1
+		}
+	}
 
 	//TODO: more thorough DKIM scoring
 
+	score -= penalty
+	if score < 0 {
+		score = 0
+	}
 	return score
 }
 
@@ -117,6 +289,7 @@ func ScoreDKIMSig(p *DKIMSigProfile) (score int) {
 //TODO: handle whitespace/char encodings
 func ParseDKIMSig(record string) *DKIMSigProfile {
 	p := DKIMSigProfile{}
+	pos := dns.TagPositions(record)
 
 	stripped := strings.Map(func(r rune) rune {
 		if unicode.IsSpace(r) {
@@ -127,26 +300,38 @@ func ParseDKIMSig(record string) *DKIMSigProfile {
 
 	for key, value := range parseParams(stripped) {
 
+		var err error
 		switch key {
 		case "v", "l", "t", "x":
-			err := reflections.SetField(&p, strings.ToUpper(key), parseInt(value))
-			handleError(err)
+			var i int64
+			if i, err = parseInt(value); err == nil {
+				err = reflections.SetField(&p, strings.ToUpper(key), i)
+			}
 		case "h":
 			split := strings.Split(value, ":")
-			err := reflections.SetField(&p, strings.ToUpper(key), split)
-			handleError(err)
+			err = reflections.SetField(&p, strings.ToUpper(key), split)
 		case "z":
 			m := make(map[string]string)
 			for _, v := range strings.Split(value, "|") {
 				s := strings.SplitN(v, ":", 2)
+				if len(s) != 2 {
+					err = fmt.Errorf("copied header field %q has no value", v)
+					continue
+				}
 				m[s[0]] = s[1]
 			}
 			p.Z = m
+		case "a", "b", "bh", "d", "s", "c", "i", "q":
+			err = reflections.SetField(&p, strings.ToUpper(key), value)
 		default:
-			err := reflections.SetField(&p, strings.ToUpper(key), value)
-			handleError(err)
+			//unrecognised tags must be ignored, see https://tools.ietf.org/html/rfc6376 section 3.2
+		}
+
+		if err != nil {
+			p.Diagnostics = append(p.Diagnostics, dns.Diagnostic{Pos: pos[key], Tag: key, Err: err})
 		}
 	}
 
+	sort.SliceStable(p.Diagnostics, func(i, j int) bool { return p.Diagnostics[i].Pos < p.Diagnostics[j].Pos })
 	return &p
 }
@@ -65,4 +65,146 @@ func TestParseDKIMSig(t *testing.T) {
             t.Errorf("ParseDKIMSig was %q \n want %q\n", p, tt.result)
         }
     }
-}
\ No newline at end of file
+}
+
+var ParseDKIMSigDiagnosticsTests = []struct {
+    record string
+
+    tags []string
+}{
+    {record: `v=1; a=rsa-sha256; l=lots; d=example.net`,
+     tags: []string{"l"}},
+    {record: `v=one; x=1118006938; z=From`,
+     tags: []string{"v", "z"}},
+    {record: `v=1; a=rsa-sha256; unknown=tag;`,
+     tags: nil},
+}
+
+func TestParseDKIMSigDiagnostics(t *testing.T) {
+    for _, tt := range ParseDKIMSigDiagnosticsTests {
+        p := ParseDKIMSig(tt.record)
+
+        var tags []string
+        for _, d := range p.Diagnostics {
+            tags = append(tags, d.Tag)
+        }
+        if !reflect.DeepEqual(tt.tags, tags) {
+            t.Errorf("ParseDKIMSig diagnostics for %q were %v\n want %v\n", tt.record, tags, tt.tags)
+        }
+    }
+}
+
+var AuditDKIMSigTests = []struct {
+    record string
+    body   string
+
+    findings []int
+}{
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; h=from:to; bh=abc=; b=abc=`,
+     findings: []int{SIG_INJECTABLE_HEADERS}},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; i=joe@mail.example.net; q=dns/txt; h=from:to; bh=abc=; b=abc=`,
+     findings: []int{SIG_INJECTABLE_HEADERS}},
+    {record: `v=2; a=rsa-sha256; d=example.net; s=s1; i=joe@example.com; q=http/well-known; h=to:DKIM-Signature; bh=abc=; b=abc=`,
+     findings: []int{SIG_BAD_VERSION, SIG_SIGNS_ITSELF, SIG_FROM_UNSIGNED, SIG_IDENTITY_OUTSIDE_D, SIG_UNKNOWN_QUERY, SIG_INJECTABLE_HEADERS}},
+    //i= must be a subdomain, not just end with d=
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; i=@badexample.net; h=from; bh=abc=; b=abc=`,
+     findings: []int{SIG_IDENTITY_OUTSIDE_D, SIG_INJECTABLE_HEADERS}},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; t=4102444800; x=4102444700; h=from; bh=abc=; b=abc=`,
+     findings: []int{SIG_FUTURE_TIMESTAMP, SIG_EXPIRES_BEFORE_SIGNED, SIG_INJECTABLE_HEADERS}},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; t=1117574938; x=1118006938; h=from; bh=abc=; b=abc=`,
+     findings: []int{SIG_EXPIRED, SIG_INJECTABLE_HEADERS}},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; l=5; h=from; bh=abc=; b=abc=`,
+     findings: []int{SIG_BODY_LENGTH, SIG_INJECTABLE_HEADERS}},
+    //"Hi.\r\n" is exactly 5 octets
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; l=5; h=from; bh=abc=; b=abc=`, body: "Hi.\n\n",
+     findings: []int{SIG_BODY_LENGTH, SIG_INJECTABLE_HEADERS}},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; l=5; h=from; bh=abc=; b=abc=`, body: "Hi.\nBuy now!\n",
+     findings: []int{SIG_BODY_LENGTH, SIG_UNSIGNED_CONTENT, SIG_INJECTABLE_HEADERS}},
+    //every critical header over-signed
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; h=from:from:subject:subject:reply-to:reply-to:to:to:date:date:content-type:content-type; bh=abc=; b=abc=`,
+     findings: nil},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; l=50; h=from; bh=abc=; b=abc=`, body: "Hi.\n",
+     findings: []int{SIG_BODY_LENGTH, SIG_BODY_LENGTH_EXCEEDED, SIG_INJECTABLE_HEADERS}},
+}
+
+func TestAuditDKIMSig(t *testing.T) {
+    for _, tt := range AuditDKIMSigTests {
+        var m *Message
+        if tt.body != "" {
+            m = &Message{Body: toCRLF([]byte(tt.body))}
+        }
+
+        if findings := AuditDKIMSig(ParseDKIMSig(tt.record), m); !reflect.DeepEqual(findings, tt.findings) {
+            t.Errorf("AuditDKIMSig for %q was %v \n want %v\n", tt.record, findings, tt.findings)
+        }
+    }
+}
+
+var ScoreDKIMSigTests = []struct {
+    record string
+
+    score int
+}{
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; t=1117574938; h=from:to:subject; bh=abc=; b=abc=`, score: 5},
+    {record: `v=1; a=rsa-sha1; d=example.net; s=s1; h=from:to:subject; bh=abc=; b=abc=`, score: 4},
+    //l= is a risk, the rest make the signature worthless
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; l=100; h=from:to:subject; bh=abc=; b=abc=`, score: 3},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; h=to:subject; bh=abc=; b=abc=`, score: 0},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; i=@example.com; h=from:to:subject; bh=abc=; b=abc=`, score: 0},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; t=1117574938; x=1118006938; h=from:to:subject; bh=abc=; b=abc=`, score: 0},
+    //coverage of the critical headers counts, not the number of fields signed
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; h=from:from:subject:subject:to; bh=abc=; b=abc=`, score: 7},
+    {record: `v=1; a=rsa-sha256; d=example.net; s=s1; h=from:keywords:keywords:message-id:mime-version; bh=abc=; b=abc=`, score: 3},
+}
+
+func TestScoreDKIMSig(t *testing.T) {
+    for _, tt := range ScoreDKIMSigTests {
+        if score := ScoreDKIMSig(ParseDKIMSig(tt.record)); score != tt.score {
+            t.Errorf("ScoreDKIMSig for %q was %d \n want %d\n", tt.record, score, tt.score)
+        }
+    }
+}
+
+func TestDKIMCoverage(t *testing.T) {
+    m, _ := ParseMessage([]byte(footballMessage))
+    injected, _ := ParseMessage([]byte("From: Mallory <mallory@example.org>\n" + footballMessage))
+    p := ParseDKIMSig(ed25519Signature[len("DKIM-Signature:"):])
+
+    var DKIMCoverageTests = []struct {
+        m *Message
+
+        coverage []HeaderCoverage
+        findings []int
+    }{
+        {m, []HeaderCoverage{{"from", 1, 2}, {"subject", 1, 2}, {"reply-to", 0, 0}, {"to", 1, 1}, {"date", 1, 2}, {"content-type", 0, 0}},
+         []int{SIG_INJECTABLE_HEADERS}},
+        {injected, []HeaderCoverage{{"from", 2, 2}, {"subject", 1, 2}, {"reply-to", 0, 0}, {"to", 1, 1}, {"date", 1, 2}, {"content-type", 0, 0}},
+         []int{SIG_INJECTABLE_HEADERS, SIG_DUPLICATE_HEADERS}},
+        //each signed field is assumed to appear once
+        {nil, []HeaderCoverage{{"from", 1, 2}, {"subject", 1, 2}, {"reply-to", 0, 0}, {"to", 1, 1}, {"date", 1, 2}, {"content-type", 0, 0}},
+         []int{SIG_INJECTABLE_HEADERS}},
+    }
+
+    for _, tt := range DKIMCoverageTests {
+        if coverage := DKIMCoverage(p, tt.m); !reflect.DeepEqual(coverage, tt.coverage) {
+            t.Errorf("DKIMCoverage was %v \n want %v\n", coverage, tt.coverage)
+        }
+        var findings []int
+        for _, f := range AuditDKIMSig(p, tt.m) {
+            if f != SIG_EXPIRED && f != SIG_FUTURE_TIMESTAMP {
+                findings = append(findings, f)
+            }
+        }
+        if !reflect.DeepEqual(findings, tt.findings) {
+            t.Errorf("AuditDKIMSig findings were %v \n want %v\n", findings, tt.findings)
+        }
+    }
+
+    //a signature whose message has had a From added is worthless even though it verifies
+    if s := scoreDKIMSig(p, AuditDKIMSig(p, injected), DKIMCoverage(p, injected)); s != 0 {
+        t.Errorf("scoreDKIMSig for a message with an injected From was %d\n", s)
+    }
+    if s := scoreDKIMSig(p, AuditDKIMSig(p, m), DKIMCoverage(p, m)); s != 1+4+3 {
+        t.Errorf("scoreDKIMSig for an over-signed message was %d\n want %d\n", s, 1+4+3)
+    }
+}
@@ -0,0 +1,190 @@
+package email
+
+import (
+	dns "bankrank/dns"
+	"mime"
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// An RFC 5322 message, see https://tools.ietf.org/html/rfc5322 section 2
+type Message struct {
+	Fields []Field //in the order they appear, repeated fields included
+	Body   []byte
+}
+
+func ParseMessage(raw []byte) (*Message, error) {
+	fields, body, err := splitMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{fields, body}, nil
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+// The decoded value of the first field called name, or "" if there isn't one
+func (m *Message) Get(name string) string {
+	if all := m.GetAll(name); len(all) > 0 {
+		return all[0]
+	}
+	return ""
+}
+
+// The decoded values of every field called name, top to bottom
+func (m *Message) GetAll(name string) (values []string) {
+	for _, f := range m.Fields {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, decodeHeader(f.Value()))
+		}
+	}
+	return
+}
+
+// RFC 2047 encoded-words are decoded where possible, otherwise the value is kept as it is
+func decodeHeader(v string) string {
+	decoded, err := wordDecoder.DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
+
+// The address in the From field
+func (m *Message) From() string {
+	addr, err := mail.ParseAddress(m.Get("From"))
+	if err != nil {
+		return ""
+	}
+	return addr.Address
+}
+
+/*
+   The envelope sender recorded in Return-Path, "" for the null path <>.
+   ok is false when there's no Return-Path, or it's empty, so nothing is known about the envelope.
+*/
+func (m *Message) ReturnPath() (path string, ok bool) {
+	rp := strings.TrimSpace(m.Get("Return-Path"))
+	if rp == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(rp, "<"), ">"), true
+}
+
+func (m *Message) Received() []string {
+	return m.GetAll("Received")
+}
+
+func (m *Message) AuthenticationResults() []string {
+	return m.GetAll("Authentication-Results")
+}
+
+// DKIM-Signature values are parsed raw, b= and bh= must not go through RFC 2047 decoding
+func (m *Message) DKIMSignatures() (sigs []*DKIMSigProfile) {
+	for _, f := range m.Fields {
+		if strings.EqualFold(f.Name, "DKIM-Signature") {
+			sigs = append(sigs, ParseDKIMSig(f.Value()))
+		}
+	}
+	return
+}
+
+var receivedHELO = regexp.MustCompile(`^\s*from\s+([A-Za-z0-9][A-Za-z0-9.-]*)`)
+
+// The HELO/EHLO name the client gave in the topmost Received field, "" if it only gave an address literal
+func (m *Message) HELO() string {
+	received := m.Received()
+	if len(received) == 0 {
+		return ""
+	}
+	if match := receivedHELO.FindStringSubmatch(received[0]); match != nil {
+		return strings.TrimSuffix(match[1], ".")
+	}
+	return ""
+}
+
+var receivedIP = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]`)
+
+/*
+   The address of the host which handed the message to us, taken from the topmost Received field,
+   e.g. "from mail.example.com (mail.example.com [192.0.2.1]) by mx.example.net ..."
+*/
+func (m *Message) ClientIP() net.IP {
+	received := m.Received()
+	if len(received) == 0 {
+		return nil
+	}
+	match := receivedIP.FindStringSubmatch(received[0])
+	if match == nil {
+		return nil
+	}
+	return net.ParseIP(match[1])
+}
+
+type MessageProfile struct {
+	From       string
+	ReturnPath string
+	NullPath   bool //Return-Path was <>, as for bounces
+	HELO       string
+	IP         net.IP
+
+	DKIM []DKIMResult
+
+	SPFResult      int //dns.PASS etc. from check_host(), dns.NONE if it couldn't be run
+	SPFExplanation string
+	SPF            *dns.SPFProfile
+}
+
+/*
+   Verifies the DKIM signatures and SPF of a raw message.
+   SPF is checked for the domain of Return-Path against the client IP in the topmost Received field.
+   For the null reverse-path the HELO identity is checked instead, see https://tools.ietf.org/html/rfc7208 section 2.4,
+   and SPF is none without one, or without a Return-Path at all. From is never used,
+   it's what SPF results get aligned with by DMARC.
+*/
+func ParseMessageProfile(r dns.Resolver, raw []byte) (*MessageProfile, error) {
+	m, err := ParseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p := MessageProfile{From: m.From(), HELO: m.HELO(), IP: m.ClientIP(), SPFResult: dns.NONE}
+	rp, ok := m.ReturnPath()
+	p.ReturnPath, p.NullPath = rp, ok && rp == ""
+
+	for _, f := range m.Fields {
+		if strings.EqualFold(f.Name, "DKIM-Signature") {
+			p.DKIM = append(p.DKIM, VerifyDKIMSignature(r, m.Fields, m.Body, f))
+		}
+	}
+
+	//CheckHost treats a sender without an @ as a HELO identity, checked as postmaster@<HELO>
+	sender := p.ReturnPath
+	if p.NullPath {
+		sender = p.HELO
+	}
+	domain := sender[strings.LastIndex(sender, "@")+1:]
+	if p.IP != nil && domain != "" {
+		p.SPFResult, p.SPFExplanation, _ = dns.CheckHost(r, p.IP, domain, sender, p.HELO)
+		p.SPF = dns.ResolveSPF(r, domain, p.IP.String())
+	}
+
+	return &p, nil
+}
+
+// Passing DKIM signatures are scored like ScoreDKIMSig, taking the body into account, and a passing SPF check with dns.ScoreSPF
+func ScoreMessage(p *MessageProfile) (score float64) {
+	for _, d := range p.DKIM {
+		if d.Result == dns.PASS {
+			score += float64(scoreDKIMSig(d.Signature, d.Findings, d.Coverage))
+		}
+	}
+
+	if p.SPFResult == dns.PASS && p.SPF != nil {
+		score += dns.ScoreSPF(p.SPF)
+	}
+
+	return
+}
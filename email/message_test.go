@@ -0,0 +1,95 @@
+package email
+
+import (
+    dns "bankrank/dns"
+    "net"
+    "reflect"
+    "testing"
+)
+
+const receivedHeaders = `Return-Path: <joe@football.example.com>
+Received: from mail.football.example.com (mail.football.example.com [192.0.2.1])
+	by mx.shopping.example.net with ESMTPS id 1234;
+	Fri, 11 Jul 2003 21:01:00 -0700
+Received: from [10.0.0.5] by mail.football.example.com; Fri, 11 Jul 2003 21:00:40 -0700
+Authentication-Results: mx.shopping.example.net; spf=pass smtp.mailfrom=football.example.com
+X-Note: =?UTF-8?B?wqFIb2xhIQ==?=
+`
+
+func TestParseMessage(t *testing.T) {
+    m, err := ParseMessage([]byte(receivedHeaders + rsaSignature + footballMessage))
+    if err != nil {
+        t.Fatalf("ParseMessage returned %v", err)
+    }
+
+    if from := m.From(); from != "joe@football.example.com" {
+        t.Errorf("From was %q", from)
+    }
+    if rp, ok := m.ReturnPath(); rp != "joe@football.example.com" || !ok {
+        t.Errorf("ReturnPath was %q, %v", rp, ok)
+    }
+    if r := m.Received(); len(r) != 2 || r[0] != "from mail.football.example.com (mail.football.example.com [192.0.2.1])\tby mx.shopping.example.net with ESMTPS id 1234;\tFri, 11 Jul 2003 21:01:00 -0700" {
+        t.Errorf("Received was %q", r)
+    }
+    if ip := m.ClientIP(); !ip.Equal(net.ParseIP("192.0.2.1")) {
+        t.Errorf("ClientIP was %s", ip)
+    }
+    if helo := m.HELO(); helo != "mail.football.example.com" {
+        t.Errorf("HELO was %q", helo)
+    }
+    if ar := m.AuthenticationResults(); !reflect.DeepEqual(ar, []string{"mx.shopping.example.net; spf=pass smtp.mailfrom=football.example.com"}) {
+        t.Errorf("AuthenticationResults was %q", ar)
+    }
+    if note := m.Get("x-note"); note != "¡Hola!" {
+        t.Errorf("X-Note was %q, want it decoded", note)
+    }
+    if sigs := m.DKIMSignatures(); len(sigs) != 1 || sigs[0].S != "test" {
+        t.Errorf("DKIMSignatures was %+v", sigs)
+    }
+    if string(m.Body) != "Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n" {
+        t.Errorf("Body was %q", m.Body)
+    }
+}
+
+var MessageProfileTests = []struct {
+    message string
+
+    dkim []int
+    spf  int
+}{
+    {message: receivedHeaders + ed25519Signature + rsaSignature + footballMessage, dkim: []int{dns.PASS, dns.PASS}, spf: dns.PASS},
+    {message: "Return-Path: <joe@football.example.com>\nReceived: from evil.example.org [198.51.100.1] by mx;\n" + rsaSignature + footballMessage,
+     dkim: []int{dns.PASS}, spf: dns.FAIL},
+    //the null reverse-path checks the HELO identity, never From
+    {message: "Return-Path: <>\nReceived: from football.example.com (football.example.com [192.0.2.1]) by mx;\n" + footballMessage,
+     dkim: nil, spf: dns.PASS},
+    {message: "Return-Path: <>\nReceived: from evil.example.org [192.0.2.1] by mx;\n" + footballMessage, dkim: nil, spf: dns.NONE},
+    {message: "Received: from [192.0.2.1] by mx;\n" + footballMessage, dkim: nil, spf: dns.NONE},
+    //without a Return-Path nothing is known about the envelope, it isn't taken to be a bounce
+    {message: "Received: from football.example.com (football.example.com [192.0.2.1]) by mx;\n" + footballMessage,
+     dkim: nil, spf: dns.NONE},
+    {message: footballMessage, dkim: nil, spf: dns.NONE},
+}
+
+func TestParseMessageProfile(t *testing.T) {
+    for _, tt := range MessageProfileTests {
+        p, err := ParseMessageProfile(dkimKeys, []byte(tt.message))
+        if err != nil {
+            t.Errorf("ParseMessageProfile for %q returned %v\n", tt.message, err)
+            continue
+        }
+
+        var dkim []int
+        for _, d := range p.DKIM {
+            dkim = append(dkim, d.Result)
+        }
+        if !reflect.DeepEqual(dkim, tt.dkim) || p.SPFResult != tt.spf {
+            t.Errorf("MessageProfile for %q had DKIM %v and SPF %d \n want %v and %d\n", tt.message, dkim, p.SPFResult, tt.dkim, tt.spf)
+        }
+    }
+
+    p, _ := ParseMessageProfile(dkimKeys, []byte(receivedHeaders + rsaSignature + footballMessage))
+    if s := ScoreMessage(p); s <= float64(ScoreDKIMSig(p.DKIM[0].Signature)) {
+        t.Errorf("ScoreMessage for a message passing DKIM and SPF was %f", s)
+    }
+}
@@ -0,0 +1,171 @@
+package email
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+   The fields RFC 6376 section 5.4.1 suggests signing, those present in a message are signed by default.
+   The CriticalHeaders are over-signed, named once more than they appear, so none can be added after signing.
+*/
+var DefaultSignedHeaders = []string{
+	"from", "reply-to", "subject", "date", "to", "cc", "message-id",
+	"in-reply-to", "references", "mime-version", "content-type", "content-transfer-encoding",
+	"resent-date", "resent-from", "resent-to", "resent-cc", "list-id", "list-unsubscribe", "list-unsubscribe-post",
+}
+
+// What a DKIM-Signature is made with
+type DKIMSigner struct {
+	Key      crypto.Signer //*rsa.PrivateKey or ed25519.PrivateKey
+	Domain   string        //d=
+	Selector string        //s=
+
+	Canonicalization string   //c=, relaxed/relaxed if empty
+	Headers          []string //h=, the DefaultSignedHeaders present in the message, over-signed, if empty
+	Identity         string   //i=, optional
+
+	//t= and x=, omitted if zero so that fixtures are reproducible
+	Timestamp time.Time
+	Expires   time.Time
+}
+
+// Signs a raw message, returning it with the DKIM-Signature field prepended
+func SignDKIM(s *DKIMSigner, message []byte) ([]byte, error) {
+	fields, body, err := splitMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := NewDKIMSignature(s, fields, body)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(sig.Raw), toCRLF(message)...), nil
+}
+
+/*
+   Makes a DKIM-Signature field for a message, see https://tools.ietf.org/html/rfc6376 section 5.
+   RSA and Ed25519 signatures are deterministic, so the same message, signer and time always give the same field.
+*/
+func NewDKIMSignature(s *DKIMSigner, fields []Field, body []byte) (Field, error) {
+	var algorithm string
+	var opts crypto.SignerOpts
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		algorithm, opts = "rsa-sha256", crypto.SHA256
+	case ed25519.PrivateKey:
+		//the SHA-256 digest is signed as the message, see https://tools.ietf.org/html/rfc8463 section 3
+		algorithm, opts = "ed25519-sha256", crypto.Hash(0)
+	default:
+		return Field{}, fmt.Errorf("unsupported key type %T", s.Key)
+	}
+
+	if s.Domain == "" || s.Selector == "" {
+		return Field{}, errors.New("a domain and selector are required")
+	}
+
+	canon := s.Canonicalization
+	if canon == "" {
+		canon = "relaxed/relaxed"
+	}
+	headerCanon, bodyCanon, err := parseCanonicalization(canon)
+	if err != nil {
+		return Field{}, err
+	}
+
+	hasFrom := false
+	for _, f := range fields {
+		hasFrom = hasFrom || strings.EqualFold(f.Name, "From")
+	}
+	if !hasFrom {
+		return Field{}, errors.New("the message has no From field")
+	}
+
+	h := s.Headers
+	if len(h) == 0 {
+		for _, name := range DefaultSignedHeaders {
+			for _, f := range fields {
+				if strings.EqualFold(f.Name, name) {
+					h = append(h, name)
+				}
+			}
+			if contains(CriticalHeaders, name) {
+				h = append(h, name)
+			}
+		}
+	}
+
+	bh := newHash(crypto.SHA256)
+	bh.Write(canonicalBody(body, bodyCanon))
+
+	tags := []string{"v=1", "a=" + algorithm, "c=" + canon, "d=" + s.Domain, "s=" + s.Selector}
+	if s.Identity != "" {
+		tags = append(tags, "i="+s.Identity)
+	}
+	if !s.Timestamp.IsZero() {
+		tags = append(tags, fmt.Sprintf("t=%d", s.Timestamp.Unix()))
+	}
+	if !s.Expires.IsZero() {
+		tags = append(tags, fmt.Sprintf("x=%d", s.Expires.Unix()))
+	}
+	tags = append(tags, "h="+strings.Join(h, ":"), "bh="+b64.StdEncoding.EncodeToString(bh.Sum(nil)))
+	//b= goes on its own lines
+	sig := Field{"DKIM-Signature", "DKIM-Signature: " + foldTags(tags) + ";\r\n\tb=\r\n"}
+
+	//refuse to make a signature a verifier would reject
+	p := ParseDKIMSig(sig.Value() + "AA==")
+	if err := checkDKIMSig(p); err != nil {
+		return Field{}, err
+	}
+	if p.X > 0 && p.T > 0 && p.X <= p.T {
+		return Field{}, errors.New("x= must be after t=")
+	}
+
+	digest := newHash(crypto.SHA256)
+	digest.Write(signedData(fields, h, sig, headerCanon))
+	b, err := s.Key.Sign(rand.Reader, digest.Sum(nil), opts)
+	if err != nil {
+		return Field{}, err
+	}
+
+	sig.Raw = strings.TrimSuffix(sig.Raw, "\r\n") + foldValue(b64.StdEncoding.EncodeToString(b)) + "\r\n"
+	return sig, nil
+}
+
+// Joins tags with "; ", folding before any which would take the line past 78 characters
+func foldTags(tags []string) string {
+	var b strings.Builder
+	line := len("DKIM-Signature: ")
+	for i, tag := range tags {
+		if i > 0 {
+			b.WriteString(";")
+			if line+len(tag)+2 > 78 {
+				b.WriteString("\r\n\t")
+				line = 8
+			} else {
+				b.WriteString(" ")
+				line += 2
+			}
+		}
+		b.WriteString(tag)
+		line += len(tag) + 1
+	}
+	return b.String()
+}
+
+// Breaks a long base64 value over several lines, whitespace within it is ignored
+func foldValue(v string) string {
+	var lines []string
+	for len(v) > 64 {
+		lines, v = append(lines, v[:64]), v[64:]
+	}
+	return strings.Join(append(lines, v), "\r\n\t ")
+}
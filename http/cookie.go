@@ -0,0 +1,217 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One Set-Cookie header, see https://tools.ietf.org/html/rfc6265 section 5.2
+type CookieProfile struct {
+	Name        string
+	Domain      string //"" for a host-only cookie
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    string //"strict", "lax", "none", or "" if missing or unrecognised
+	Partitioned bool
+
+	MaxAge  int64     //0 if not set
+	Expires time.Time //zero if not set
+	//a persistent cookie outlives the browser session
+	Persistent bool
+
+	Session     bool //the name looks like it holds a session or authentication token
+	Findings    []int
+	Diagnostics []Diagnostic
+}
+
+// What can be wrong with a cookie
+const (
+	COOKIE_INSECURE          = iota //0, session cookie sent over HTTP
+	COOKIE_SCRIPT_ACCESSIBLE        //1, session cookie readable by JavaScript, and so by XSS
+	COOKIE_PARENT_DOMAIN            //2, session cookie sent to every subdomain of a parent domain
+	COOKIE_CROSS_SITE               //3, SameSite=None, sent with cross-site requests
+	COOKIE_REJECTED                 //4, SameSite=None without Secure, or a prefix whose rules are broken
+	COOKIE_LONG_LIVED               //5, session cookie which outlives the browser session by more than a day
+)
+
+var CookieFindings = map[int]string{
+	COOKIE_INSECURE:          "session cookie without Secure",
+	COOKIE_SCRIPT_ACCESSIBLE: "session cookie without HttpOnly",
+	COOKIE_PARENT_DOMAIN:     "session cookie scoped to a parent domain",
+	COOKIE_CROSS_SITE:        "sent cross-site",
+	COOKIE_REJECTED:          "rejected by browsers",
+	COOKIE_LONG_LIVED:        "long lived session cookie",
+}
+
+var sessionCookie = regexp.MustCompile(`(?i)sess|sid$|^sid|auth|token|login|jwt|remember`)
+
+// Anti-CSRF tokens, e.g. XSRF-TOKEN or __RequestVerificationToken, are meant to be read by JavaScript
+var csrfCookie = regexp.MustCompile(`(?i)csrf|xsrf|verification`)
+
+// Parses every Set-Cookie header of resp
+func ParseCookies(resp *http.Response) (cookies []*CookieProfile) {
+	host := ""
+	if resp.Request != nil && resp.Request.URL != nil {
+		host = strings.ToLower(resp.Request.URL.Hostname())
+	}
+
+	for _, v := range resp.Header.Values("Set-Cookie") {
+		if c := ParseCookie(v, host); c != nil {
+			cookies = append(cookies, c)
+		}
+	}
+	return
+}
+
+/*
+   Parses a Set-Cookie header value set by host, which may be "" if it isn't known.
+   Returns nil for a header without a name=value pair, which browsers ignore.
+*/
+func ParseCookie(v string, host string) *CookieProfile {
+	const header = "Set-Cookie"
+
+	parts := strings.Split(v, ";")
+	pair := strings.SplitN(parts[0], "=", 2)
+	if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+		return nil
+	}
+
+	c := CookieProfile{Name: strings.TrimSpace(pair[0])}
+
+	for _, attr := range parts[1:] {
+		kv := strings.SplitN(attr, "=", 2)
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+
+		switch name {
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "partitioned":
+			c.Partitioned = true
+		case "domain":
+			//a leading dot is ignored, see https://tools.ietf.org/html/rfc6265 section 5.2.3
+			c.Domain = strings.ToLower(strings.TrimPrefix(value, "."))
+		case "path":
+			c.Path = value
+		case "samesite":
+			switch strings.ToLower(value) {
+			case "strict", "lax", "none":
+				c.SameSite = strings.ToLower(value)
+			default:
+				c.Diagnostics = append(c.Diagnostics, Diagnostic{header, "SameSite", errors.New("unrecognised value " + value)})
+			}
+		case "max-age":
+			maxage, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				c.Diagnostics = append(c.Diagnostics, Diagnostic{header, "Max-Age", err})
+				continue
+			}
+			c.MaxAge = maxage
+		case "expires":
+			expires, err := parseCookieTime(value)
+			if err != nil {
+				c.Diagnostics = append(c.Diagnostics, Diagnostic{header, "Expires", err})
+				continue
+			}
+			c.Expires = expires
+		case "":
+		default:
+			c.Diagnostics = append(c.Diagnostics, Diagnostic{header, name, errors.New("unknown attribute")})
+		}
+	}
+
+	//Max-Age takes precedence over Expires
+	c.Persistent = c.MaxAge != 0 || !c.Expires.IsZero()
+	c.Session = sessionCookie.MatchString(c.Name) && !csrfCookie.MatchString(c.Name)
+	c.Findings = cookieFindings(&c, host)
+
+	return &c
+}
+
+// Expires is usually an RFC 1123 date, but older formats, e.g. with dashes, are still sent
+func parseCookieTime(v string) (time.Time, error) {
+	for _, layout := range []string{time.RFC1123, "Mon, 02-Jan-2006 15:04:05 MST", time.RFC850, time.ANSIC} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognised date " + v)
+}
+
+func cookieFindings(c *CookieProfile, host string) (findings []int) {
+	if c.Session {
+		if !c.Secure {
+			findings = append(findings, COOKIE_INSECURE)
+		}
+		if !c.HttpOnly {
+			findings = append(findings, COOKIE_SCRIPT_ACCESSIBLE)
+		}
+		//a Domain equal to the host is deliberately exempt: it does share the cookie with the host's own
+		//subdomains, but no sibling can read it, and ScoreCookie already withholds the host-only point
+		if c.Domain != "" && c.Domain != host {
+			findings = append(findings, COOKIE_PARENT_DOMAIN)
+		}
+	}
+
+	if c.SameSite == "none" {
+		findings = append(findings, COOKIE_CROSS_SITE)
+	}
+
+	//See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Set-Cookie#cookie_prefixes
+	rejected := c.SameSite == "none" && !c.Secure
+	switch {
+	case strings.HasPrefix(c.Name, "__Host-"):
+		rejected = rejected || !c.Secure || c.Domain != "" || c.Path != "/"
+	case strings.HasPrefix(c.Name, "__Secure-"):
+		rejected = rejected || !c.Secure
+	}
+	if rejected {
+		findings = append(findings, COOKIE_REJECTED)
+	}
+
+	day := int64(24 * time.Hour / time.Second)
+	if c.Session && (c.MaxAge > day || (c.MaxAge == 0 && c.Expires.After(time.Now().Add(24*time.Hour)))) {
+		findings = append(findings, COOKIE_LONG_LIVED)
+	}
+
+	return
+}
+
+/*
+   A point each for Secure, HttpOnly, host-only (no Domain), a __Host- prefix and SameSite=Lax, two for SameSite=Strict.
+   Session cookies lacking Secure or HttpOnly, or scoped to a parent domain, score nothing,
+   as do cookies browsers would reject. Other findings and each diagnostic cost a point.
+*/
+func ScoreCookie(c *CookieProfile) (s int) {
+	for _, f := range c.Findings {
+		switch f {
+		case COOKIE_INSECURE, COOKIE_SCRIPT_ACCESSIBLE, COOKIE_PARENT_DOMAIN, COOKIE_REJECTED:
+			return 0
+		}
+	}
+
+	s = sm[c.Secure] + sm[c.HttpOnly] + sm[c.Domain == ""] + sm[strings.HasPrefix(c.Name, "__Host-")]
+	s += map[string]int{"strict": 2, "lax": 1}[c.SameSite]
+
+	return penalise(s-len(c.Findings), c.Diagnostics)
+}
+
+// A site is only as good as its weakest cookie, 0 if it sets none
+func ScoreCookies(cookies []*CookieProfile) (s int) {
+	for i, c := range cookies {
+		if score := ScoreCookie(c); i == 0 || score < s {
+			s = score
+		}
+	}
+	return
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+    "net/http"
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+)
+
+var parseCookieTests = []struct {
+    s string
+    p *CookieProfile
+}{
+    {s: `__Host-SESSIONID=abc123; Path=/; Secure; HttpOnly; SameSite=Strict`,
+     p: &CookieProfile{Name: "__Host-SESSIONID", Path: "/", Secure: true, HttpOnly: true, SameSite: "strict", Session: true}},
+    {s: `JSESSIONID=abc123; Domain=.bank.com; Path=/; HttpOnly`,
+     p: &CookieProfile{Name: "JSESSIONID", Domain: "bank.com", Path: "/", HttpOnly: true, Session: true,
+                       Findings: []int{COOKIE_INSECURE, COOKIE_PARENT_DOMAIN}}},
+    {s: `lang=en-GB; Max-Age=31536000; SameSite=None; Partitioned`,
+     p: &CookieProfile{Name: "lang", SameSite: "none", Partitioned: true, MaxAge: 31536000, Persistent: true,
+                       Findings: []int{COOKIE_CROSS_SITE, COOKIE_REJECTED}}},
+    {s: `remember_me=1; Expires=Wed, 21-Oct-2099 07:28:00 GMT; Secure; HttpOnly; Domain=www.bank.com`,
+     p: &CookieProfile{Name: "remember_me", Domain: "www.bank.com", Secure: true, HttpOnly: true, Session: true,
+                       Expires: time.Date(2099, 10, 21, 7, 28, 0, 0, time.UTC), Persistent: true,
+                       Findings: []int{COOKIE_LONG_LIVED}}},
+    {s: `__Host-id=1; Secure; Path=/app`,
+     p: &CookieProfile{Name: "__Host-id", Path: "/app", Secure: true, Findings: []int{COOKIE_REJECTED}}},
+    {s: `nameless`, p: nil},
+}
+
+func TestParseCookie(t *testing.T) {
+    for _, tt := range parseCookieTests {
+        p := ParseCookie(tt.s, "www.bank.com")
+        if p != nil && tt.p != nil && p.Expires.Equal(tt.p.Expires) {
+            p.Expires = tt.p.Expires
+        }
+        if !reflect.DeepEqual(tt.p, p) {
+            t.Errorf("CookieProfile for %q = %+v\n want %+v\n", tt.s, p, tt.p)
+        }
+    }
+}
+
+var parseCookieDiagnosticsTests = []struct {
+    s          string
+    directives []string
+}{
+    {s: `id=1; Max-Age=forever; Expires=tomorrow; SameSite=Sometimes; Priority=High`,
+     directives: []string{"Max-Age", "Expires", "SameSite", "priority"}},
+    {s: `id=1; expires=Thu, 01 Jan 2099 00:00:00 GMT; ;`, directives: nil},
+}
+
+func TestParseCookieDiagnostics(t *testing.T) {
+    for _, tt := range parseCookieDiagnosticsTests {
+        var directives []string
+        for _, d := range ParseCookie(tt.s, "").Diagnostics {
+            directives = append(directives, d.Directive)
+        }
+        if !reflect.DeepEqual(directives, tt.directives) {
+            t.Errorf("Set-Cookie diagnostics for %q were %v, want %v", tt.s, directives, tt.directives)
+        }
+    }
+}
+
+var scoreCookieTests = []struct {
+    s     string
+    score int
+}{
+    {s: `__Host-SESSIONID=abc123; Path=/; Secure; HttpOnly; SameSite=Strict`, score: 6},
+    {s: `sid=abc123; Secure; HttpOnly; SameSite=Lax`, score: 4},
+    {s: `sid=abc123; Secure; SameSite=Lax`, score: 0},
+    {s: `auth_token=abc123; Domain=bank.com; Secure; HttpOnly; SameSite=Strict`, score: 0},
+    //non-session cookies don't need to be HttpOnly, but SameSite=None costs a point
+    {s: `lang=en; Secure; SameSite=None`, score: 1},
+    {s: `lang=en; SameSite=Lax; Max-Age=soon`, score: 1},
+    //anti-CSRF tokens have to be readable by JavaScript
+    {s: `XSRF-TOKEN=abc123; Secure; SameSite=Strict`, score: 4},
+    {s: `__RequestVerificationToken=abc123; Secure; SameSite=Strict`, score: 4},
+}
+
+func TestScoreCookie(t *testing.T) {
+    for _, tt := range scoreCookieTests {
+        if s := ScoreCookie(ParseCookie(tt.s, "www.bank.com")); s != tt.score {
+            t.Errorf("ScoreCookie for %q = %d, want %d", tt.s, s, tt.score)
+        }
+    }
+}
+
+func TestParseCookies(t *testing.T) {
+    r := new(http.Response)
+    r.Header = make(http.Header)
+    r.Request = &http.Request{URL: &url.URL{Scheme: "https", Host: "www.bank.com:443"}}
+    r.Header.Add("Set-Cookie", `sid=abc123; Domain=www.bank.com; Secure; HttpOnly; SameSite=Lax`)
+    r.Header.Add("Set-Cookie", `lang=en`)
+    r.Header.Add("Set-Cookie", `=nameless`)
+
+    cookies := ParseCookies(r)
+    if len(cookies) != 2 || cookies[0].Findings != nil {
+        t.Errorf("ParseCookies = %+v\n", cookies)
+    }
+    //the weakest cookie counts
+    if s := ScoreCookies(cookies); s != 1 {
+        t.Errorf("ScoreCookies = %d, want 1", s)
+    }
+    if s := ScoreCookies(cookies[:1]); s != 3 {
+        t.Errorf("ScoreCookies = %d, want 3", s)
+    }
+}
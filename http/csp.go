@@ -0,0 +1,235 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Content-Security-Policy, see https://www.w3.org/TR/CSP3/
+type CSPProfile struct {
+	Present    bool
+	ReportOnly bool //only Content-Security-Policy-Report-Only was sent, so nothing is enforced
+
+	Directives map[string][]string //directive names are lower case, sources are as sent
+
+	//from the sources which apply to scripts
+	Nonces        []string
+	Hashes        []string
+	StrictDynamic bool
+
+	ReportURI   []string
+	ReportTo    string
+	Diagnostics []Diagnostic
+}
+
+// Directives which fall back to default-src when they're missing
+var cspFetchDirectives = map[string]bool{
+	"child-src": true, "connect-src": true, "font-src": true, "frame-src": true, "img-src": true,
+	"manifest-src": true, "media-src": true, "object-src": true, "prefetch-src": true,
+	"script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "worker-src": true,
+}
+
+var cspOtherDirectives = map[string]bool{
+	"default-src": true, "base-uri": true, "sandbox": true, "form-action": true, "frame-ancestors": true,
+	"navigate-to": true, "report-uri": true, "report-to": true, "require-trusted-types-for": true,
+	"trusted-types": true, "upgrade-insecure-requests": true, "block-all-mixed-content": true,
+	"plugin-types": true, "require-sri-for": true,
+}
+
+var cspDirectiveName = regexp.MustCompile("^[a-z0-9-]+$")
+var cspHash = regexp.MustCompile("^'(sha256|sha384|sha512)-([A-Za-z0-9+/_=-]+)'$")
+var cspNonce = regexp.MustCompile("^'nonce-([A-Za-z0-9+/_=-]+)'$")
+
+/*
+   Parses the enforced policy, or the Report-Only one if that's all there is.
+   Several policies (repeated headers, or comma separated) are merged, the first to set a directive wins.
+   Browsers enforce every policy, so later ones can only make things stricter than this profile says.
+*/
+func ParseCSP(resp *http.Response) (p *CSPProfile) {
+	header := "Content-Security-Policy"
+	values := resp.Header.Values(header)
+	profile := CSPProfile{Directives: make(map[string][]string)}
+
+	if len(values) == 0 {
+		header = "Content-Security-Policy-Report-Only"
+		values = resp.Header.Values(header)
+		profile.ReportOnly = len(values) > 0
+	}
+	profile.Present = len(values) > 0
+
+	for _, v := range values {
+		for _, policy := range strings.Split(v, ",") {
+			profile.parsePolicy(header, policy)
+		}
+	}
+
+	for _, s := range profile.Sources("script-src") {
+		if m := cspNonce.FindStringSubmatch(s); m != nil {
+			profile.Nonces = append(profile.Nonces, m[1])
+		}
+		if m := cspHash.FindStringSubmatch(s); m != nil {
+			profile.Hashes = append(profile.Hashes, m[1]+"-"+m[2])
+		}
+		if strings.EqualFold(s, "'strict-dynamic'") {
+			profile.StrictDynamic = true
+		}
+	}
+
+	profile.ReportURI = profile.Directives["report-uri"]
+	if to := profile.Directives["report-to"]; len(to) > 0 {
+		profile.ReportTo = to[0]
+	}
+
+	return &profile
+}
+
+func (p *CSPProfile) parsePolicy(header string, policy string) {
+	seen := make(map[string]bool)
+
+	for _, d := range strings.Split(policy, ";") {
+		tokens := strings.Fields(d)
+		if len(tokens) == 0 {
+			continue
+		}
+		name := strings.ToLower(tokens[0])
+
+		switch {
+		case !cspDirectiveName.MatchString(name):
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{header, tokens[0], errors.New("invalid directive name")})
+			continue
+		case seen[name]:
+			//only the first instance in a policy counts
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{header, name, errors.New("repeated directive")})
+			continue
+		case !cspFetchDirectives[name] && !cspOtherDirectives[name]:
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{header, name, errors.New("unknown directive")})
+		}
+		seen[name] = true
+
+		for _, s := range tokens[1:] {
+			if isCSPKeyword(s) && !knownCSPKeyword(s) && !cspNonce.MatchString(s) && !cspHash.MatchString(s) {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{header, name, errors.New("unknown source " + s)})
+			}
+		}
+
+		if _, ok := p.Directives[name]; !ok {
+			p.Directives[name] = tokens[1:]
+		}
+	}
+}
+
+func isCSPKeyword(s string) bool {
+	return strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'")
+}
+
+func knownCSPKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "'self'", "'none'", "'unsafe-inline'", "'unsafe-eval'", "'strict-dynamic'", "'unsafe-hashes'",
+		"'report-sample'", "'wasm-unsafe-eval'", "'unsafe-allow-redirects'":
+		return true
+	}
+	return false
+}
+
+/*
+   The sources which apply to directive, following the fallback to default-src for fetch directives.
+   script-src-elem and script-src-attr fall back to script-src first, and style-src-* to style-src.
+*/
+func (p *CSPProfile) Sources(directive string) []string {
+	for d := directive; d != ""; {
+		if s, ok := p.Directives[d]; ok {
+			return s
+		}
+		switch {
+		case strings.HasPrefix(d, "script-src-"):
+			d = "script-src"
+		case strings.HasPrefix(d, "style-src-"):
+			d = "style-src"
+		case cspFetchDirectives[d]:
+			d = "default-src"
+		default:
+			d = ""
+		}
+	}
+	return nil
+}
+
+// Whether directive applies, directly or through default-src
+func (p *CSPProfile) Restricts(directive string) bool {
+	for d := directive; ; {
+		if _, ok := p.Directives[d]; ok {
+			return true
+		}
+		if !cspFetchDirectives[d] {
+			return false
+		}
+		d = "default-src"
+	}
+}
+
+func hasSource(sources []string, source string) bool {
+	for _, s := range sources {
+		if strings.EqualFold(s, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources which allow anything from a whole scheme or any host, and so do nothing against XSS
+func permissive(sources []string) bool {
+	for _, s := range sources {
+		switch strings.ToLower(s) {
+		case "*", "http:", "https:", "data:", "blob:", "filesystem:", "http://*", "https://*":
+			return true
+		}
+	}
+	return false
+}
+
+/*
+   A point each for an enforced policy, restricting scripts without 'unsafe-inline', 'unsafe-eval' or
+   permissive sources (unless 'strict-dynamic' makes browsers ignore them), using nonces or hashes,
+   object-src, base-uri, frame-ancestors, form-action and reporting.
+   A missing object-src or base-uri costs a point, as does each diagnostic.
+   A Report-Only policy protects nothing, so only scores a point if violations are reported.
+*/
+func ScoreCSP(p *CSPProfile) (s int) {
+	if !p.Present {
+		return 0
+	}
+	reporting := len(p.ReportURI) > 0 || p.ReportTo != ""
+	if p.ReportOnly {
+		return sm[reporting]
+	}
+
+	s = 1
+
+	if p.Restricts("script-src") {
+		script := p.Sources("script-src")
+		//'unsafe-inline' is ignored by browsers when there's a nonce or hash
+		s += 1 + sm[!hasSource(script, "'unsafe-inline'") || len(p.Nonces)+len(p.Hashes) > 0] +
+			sm[!hasSource(script, "'unsafe-eval'")] +
+			sm[!permissive(script) || p.StrictDynamic] +
+			sm[len(p.Nonces)+len(p.Hashes) > 0]
+	}
+
+	if !p.Restricts("object-src") {
+		s--
+	} else if object := p.Sources("object-src"); hasSource(object, "'none'") {
+		s++
+	}
+
+	if base := p.Sources("base-uri"); !p.Restricts("base-uri") {
+		s--
+	} else if hasSource(base, "'none'") || hasSource(base, "'self'") {
+		s++
+	}
+
+	s += sm[p.Restricts("frame-ancestors")] + sm[p.Restricts("form-action")] + sm[reporting]
+
+	return penalise(s, p.Diagnostics)
+}
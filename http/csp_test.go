@@ -0,0 +1,101 @@
+package http
+
+import (
+    "net/http"
+    "reflect"
+    "testing"
+)
+
+var parseCSPTests = []struct {
+    enforced   []string
+    reportOnly string
+    p          *CSPProfile
+}{
+    {enforced: []string{`default-src 'self'; script-src 'self' 'nonce-r4nd0m' 'strict-dynamic'; object-src 'none'; base-uri 'none'; report-uri /csp`},
+     p: &CSPProfile{Present: true,
+                    Directives: map[string][]string{"default-src": {"'self'"}, "script-src": {"'self'", "'nonce-r4nd0m'", "'strict-dynamic'"},
+                                                    "object-src": {"'none'"}, "base-uri": {"'none'"}, "report-uri": {"/csp"}},
+                    Nonces: []string{"r4nd0m"}, StrictDynamic: true, ReportURI: []string{"/csp"}}},
+    //hashes through default-src, and the Report-Only header is ignored when a policy is enforced
+    {enforced: []string{`DEFAULT-SRC 'sha256-B2yPHKaXnvFWtRChIbabYmUBFZdVfKKXHbWtWidDVF8='; report-to csp-endpoint`},
+     reportOnly: `default-src *`,
+     p: &CSPProfile{Present: true,
+                    Directives: map[string][]string{"default-src": {"'sha256-B2yPHKaXnvFWtRChIbabYmUBFZdVfKKXHbWtWidDVF8='"}, "report-to": {"csp-endpoint"}},
+                    Hashes: []string{"sha256-B2yPHKaXnvFWtRChIbabYmUBFZdVfKKXHbWtWidDVF8="}, ReportTo: "csp-endpoint"}},
+    {reportOnly: `script-src 'unsafe-inline'`,
+     p: &CSPProfile{Present: true, ReportOnly: true, Directives: map[string][]string{"script-src": {"'unsafe-inline'"}}}},
+    //several policies, the first to set a directive wins
+    {enforced: []string{`script-src 'self', script-src *; frame-ancestors 'none'`, `form-action 'self'`},
+     p: &CSPProfile{Present: true,
+                    Directives: map[string][]string{"script-src": {"'self'"}, "frame-ancestors": {"'none'"}, "form-action": {"'self'"}}}},
+    {p: &CSPProfile{Directives: map[string][]string{}}},
+}
+
+func cspResponse(enforced []string, reportOnly string) *http.Response {
+    r := new(http.Response)
+    r.Header = make(http.Header)
+    for _, v := range enforced {
+        r.Header.Add("Content-Security-Policy", v)
+    }
+    if reportOnly != "" {
+        r.Header.Set("Content-Security-Policy-Report-Only", reportOnly)
+    }
+    return r
+}
+
+func TestParseCSP(t *testing.T) {
+    for _, tt := range parseCSPTests {
+        if p := ParseCSP(cspResponse(tt.enforced, tt.reportOnly)); !reflect.DeepEqual(tt.p, p) {
+            t.Errorf("CSPProfile for %q = %+v\n want %+v\n", tt.enforced, p, tt.p)
+        }
+    }
+}
+
+var parseCSPDiagnosticsTests = []struct {
+    s          string
+    directives []string
+}{
+    {s: `script-src 'self'; script-src *`, directives: []string{"script-src"}},
+    {s: `script-src 'self' 'unsafe-everything'; frame-ancestor 'none'; img_src *`, directives: []string{"script-src", "frame-ancestor", "img_src"}},
+    {s: `script-src 'self' 'nonce-abc' 'sha384-abc' 'report-sample';;`, directives: nil},
+}
+
+func TestParseCSPDiagnostics(t *testing.T) {
+    for _, tt := range parseCSPDiagnosticsTests {
+        var directives []string
+        for _, d := range ParseCSP(cspResponse([]string{tt.s}, "")).Diagnostics {
+            directives = append(directives, d.Directive)
+        }
+        if !reflect.DeepEqual(directives, tt.directives) {
+            t.Errorf("CSP diagnostics for %q were %v, want %v", tt.s, directives, tt.directives)
+        }
+    }
+}
+
+var scoreCSPTests = []struct {
+    s          string
+    reportOnly bool
+    score      int
+}{
+    {s: `script-src 'nonce-abc' 'strict-dynamic' https: 'unsafe-inline'; object-src 'none'; base-uri 'none'; frame-ancestors 'self'; form-action 'self'; report-uri /csp`,
+     score: 11},
+    //the typical legacy policy, which doesn't stop XSS at all
+    {s: `default-src * 'unsafe-inline' 'unsafe-eval' data:`, score: 1 + 1 - 1},
+    {s: `default-src 'self'`, score: 1 + 4 - 1},
+    {s: `default-src 'none'; img-src 'self'`, score: 1 + 4 + 1 - 1},
+    {s: `frame-ancestors 'none'`, score: 0},
+    {s: `script-src 'nonce-abc' 'strict-dynamic'; object-src 'none'; base-uri 'none'; report-uri /csp`, reportOnly: true, score: 1},
+    {s: `script-src 'self'`, reportOnly: true, score: 0},
+}
+
+func TestScoreCSP(t *testing.T) {
+    for _, tt := range scoreCSPTests {
+        r := cspResponse([]string{tt.s}, "")
+        if tt.reportOnly {
+            r = cspResponse(nil, tt.s)
+        }
+        if s := ScoreCSP(ParseCSP(r)); s != tt.score {
+            t.Errorf("ScoreCSP for %q = %d, want %d", tt.s, s, tt.score)
+        }
+    }
+}
@@ -0,0 +1,100 @@
+//go:build ignore
+
+/*
+   Regenerates hsts_preload.json from Chromium's list, keeping only the entries which force HTTPS.
+   Run by go generate, or by hand on a copy which has already been downloaded:
+   go run gen_preload.go transport_security_state_static.json
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// gitiles serves raw files base64 encoded
+const source = "https://chromium.googlesource.com/chromium/src/+/main/net/http/transport_security_state_static.json?format=TEXT"
+
+type entry struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	Mode              string `json:"mode"`
+	IncludeSubdomains bool   `json:"include_subdomains,omitempty"`
+}
+
+func main() {
+	data, err := read()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//the list is JSON apart from // comment lines
+	var stripped bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if !strings.HasPrefix(strings.TrimSpace(scanner.Text()), "//") {
+			stripped.Write(scanner.Bytes())
+		}
+		stripped.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	var list struct {
+		Entries []entry `json:"entries"`
+	}
+	if err := json.Unmarshal(stripped.Bytes(), &list); err != nil {
+		log.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("// Chromium's HSTS preload list, keeping only the entries which force HTTPS, from\n")
+	out.WriteString("// https://chromium.googlesource.com/chromium/src/+/main/net/http/transport_security_state_static.json\n")
+	out.WriteString("// Generated by gen_preload.go, don't edit.\n")
+	out.WriteString("{\n  \"entries\": [\n")
+	n := 0
+	for _, e := range list.Entries {
+		if e.Mode != "force-https" {
+			continue
+		}
+		line, _ := json.Marshal(e)
+		if n > 0 {
+			out.WriteString(",\n")
+		}
+		out.WriteString("    ")
+		out.Write(line)
+		n++
+	}
+	out.WriteString("\n  ]\n}\n")
+
+	if err := os.WriteFile("hsts_preload.json", out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("kept %d of %d entries\n", n, len(list.Entries))
+}
+
+func read() ([]byte, error) {
+	if len(os.Args) > 1 {
+		return os.ReadFile(os.Args[1])
+	}
+
+	resp, err := http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", source, resp.Status)
+	}
+	return io.ReadAll(base64.NewDecoder(base64.StdEncoding, resp.Body))
+}
@@ -1,8 +1,10 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -12,13 +14,33 @@ type HPKPProfile struct {
 	Maxage            int64
 	Pins              []string
 	IncludeSubdomains bool
+	Diagnostics       []Diagnostic
 }
 
 type HSTSProfile struct {
 	Maxage            int64
 	IncludeSubdomains bool
-	Preload           bool
-	// TODO: check whether preload flag matches with info found on https://www.chromium.org/hsts
+	Preload           bool //only a request to be preloaded, CheckPreload says whether the domain is
+	Diagnostics       []Diagnostic
+	Conflicting       bool //several different headers were sent, browsers only use the first
+	Ignored           bool //sent over plain HTTP, which browsers ignore
+}
+
+// See https://tools.ietf.org/html/rfc6797 section 11.2 and https://hstspreload.org
+const (
+	hstsWeakMaxage = 15768000 //6 months
+	hstsGoodMaxage = 31536000 //a year, required for preloading
+)
+
+// A malformed directive found while parsing a header, the rest of the header is still parsed
+type Diagnostic struct {
+	Header    string
+	Directive string
+	Err       error
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s: %s", d.Header, d.Directive, d.Err)
 }
 
 type XSSProfile struct {
@@ -40,6 +62,19 @@ type XCTOProfile struct { // X-Content-Type-Options
 	Nosniff bool
 }
 
+type ReferrerProfile struct { // Referrer-Policy
+	Present bool
+	Policy  string //the policy browsers apply, "" if none was recognised
+}
+
+// Cross-Origin-Opener-Policy, Cross-Origin-Embedder-Policy and Cross-Origin-Resource-Policy
+type CrossOriginProfile struct {
+	COOP     string //"unsafe-none" if missing or unrecognised
+	COEP     string //"unsafe-none" if missing or unrecognised
+	CORP     string //"" if missing or unrecognised
+	Isolated bool   //COOP and COEP together give the page its own browsing context group
+}
+
 //TODO: in general we may need to handle upper/lower case headers?
 // func main() {
 // 	github := "https://github.com/"
@@ -58,12 +93,44 @@ var sm = map[bool]int{
 
 //TODO: validate HPKP pins
 func ScoreHPKP(p *HPKPProfile) (s int) {
-	return sm[p.Maxage > 0] + len(p.Pins) + sm[p.IncludeSubdomains]
+	return penalise(sm[p.Maxage > 0]+len(p.Pins)+sm[p.IncludeSubdomains], p.Diagnostics)
 }
 
-//TODO: decide on what scoring mechanism to use based on the cache time
+/*
+   Graded on max-age: 0 disables HSTS, under 6 months is weak, under a year is 2 points and a year or more 3.
+   includeSubDomains scores a point once max-age is at least 6 months, and preload one more if the header
+   would be accepted for preloading. Conflicting headers cost a point, and a header sent over HTTP scores nothing.
+*/
 func ScoreHSTS(p *HSTSProfile) (s int) {
-	return sm[p.Maxage > 0] + sm[p.IncludeSubdomains] + sm[p.Preload]
+	if p.Ignored || p.Maxage <= 0 {
+		return 0
+	}
+
+	switch {
+	case p.Maxage < hstsWeakMaxage:
+		s = 1
+	case p.Maxage < hstsGoodMaxage:
+		s = 2
+	default:
+		s = 3
+	}
+
+	if p.Maxage >= hstsWeakMaxage {
+		s += sm[p.IncludeSubdomains]
+	}
+	if p.Maxage >= hstsGoodMaxage {
+		s += sm[p.IncludeSubdomains && p.Preload]
+	}
+
+	return penalise(s-sm[p.Conflicting], p.Diagnostics)
+}
+
+// Each diagnostic costs a point, down to 0
+func penalise(s int, d []Diagnostic) int {
+	if s -= len(d); s < 0 {
+		return 0
+	}
+	return s
 }
 
 func ScoreXSS(p *XSSProfile) (s int) {
@@ -78,6 +145,39 @@ func ScoreXCTO(p *XCTOProfile) (s int) {
 	return sm[p.Present] + sm[p.Nosniff]
 }
 
+// What browsers apply without a Referrer-Policy they recognise, see https://w3c.github.io/webappsec-referrer-policy/#default-referrer-policy
+const referrerDefault = "strict-origin-when-cross-origin"
+
+/*
+   A point for setting a policy, and one each for not sending the path and query cross-origin,
+   not sending anything over a downgrade to HTTP, and not sending anything cross-origin at all.
+   Leaking full URLs, which can contain account identifiers, to third parties scores nothing.
+   Without a recognised policy browsers apply referrerDefault, which is scored as such, less the point
+   for setting it, as older browsers defaulted to no-referrer-when-downgrade.
+*/
+func ScoreReferrer(p *ReferrerProfile) (s int) {
+	if p.Policy == "" {
+		return ScoreReferrer(&ReferrerProfile{true, referrerDefault}) - 1
+	}
+	switch p.Policy {
+	case "no-referrer", "same-origin":
+		return 4
+	case "strict-origin", "strict-origin-when-cross-origin":
+		return 3
+	case "origin", "origin-when-cross-origin":
+		return 2
+	}
+	//no-referrer-when-downgrade and unsafe-url
+	return 0
+}
+
+func ScoreCrossOrigin(p *CrossOriginProfile) (s int) {
+	s = map[string]int{"same-origin": 2, "same-origin-allow-popups": 1, "noopener-allow-popups": 1}[p.COOP]
+	s += sm[p.COEP != "unsafe-none"]
+	s += map[string]int{"same-origin": 2, "same-site": 1}[p.CORP]
+	return s + sm[p.Isolated]
+}
+
 // Parses parameters of the format "xxx(=xxxx)?"
 func parseParams(header http.Header, key string) (params map[string]string) {
 	params = make(map[string]string)
@@ -111,36 +211,71 @@ func parseParams(header http.Header, key string) (params map[string]string) {
 func ParseHPKP(resp *http.Response) (p *HPKPProfile) {
 	params := parseParams(resp.Header, "Public-Key-Pins")
 
-	maxage, err := strconv.ParseInt(params["max-age"], 10, 64)
-
-	if err != nil {
-		maxage = 0
-	}
+	maxage, d := parseMaxage(params, "Public-Key-Pins")
 
 	_, includesubdomains := params["includesubdomains"]
 
 	profile := HPKPProfile{maxage,
 		strings.Split(params["pin-sha256"], ","),
-		includesubdomains}
+		includesubdomains,
+		d}
 	return &profile
 }
 
+/*
+   Only the first header counts, see https://tools.ietf.org/html/rfc6797 section 8.1,
+   and one received over HTTP must be ignored, see section 8.1 too.
+*/
 func ParseHSTS(resp *http.Response) (p *HSTSProfile) {
 	params := parseParams(resp.Header, "Strict-Transport-Security")
 
-	maxage, err := strconv.ParseInt(params["max-age"], 10, 64)
-
-	if err != nil {
-		maxage = 0
-	}
+	maxage, d := parseMaxage(params, "Strict-Transport-Security")
 
 	_, sub := params["includesubdomains"]
 	_, preload := params["preload"]
 
-	profile := HSTSProfile{maxage, sub, preload}
+	profile := HSTSProfile{maxage, sub, preload, d, false, false}
+
+	values := resp.Header.Values("Strict-Transport-Security")
+	for i := 1; i < len(values); i++ {
+		if normaliseDirectives(values[i]) != normaliseDirectives(values[0]) {
+			profile.Conflicting = true
+		}
+	}
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		profile.Ignored = resp.Request.URL.Scheme == "http"
+	}
+
 	return &profile
 }
 
+// Headers which only differ in case, whitespace or directive order are the same
+func normaliseDirectives(v string) string {
+	var directives []string
+	for _, d := range strings.Split(strings.ToLower(v), ";") {
+		if d = strings.Join(strings.Fields(d), ""); d != "" {
+			directives = append(directives, d)
+		}
+	}
+	sort.Strings(directives)
+	return strings.Join(directives, ";")
+}
+
+// A missing max-age is 0, a malformed one is 0 with a diagnostic
+func parseMaxage(params map[string]string, header string) (maxage int64, d []Diagnostic) {
+	v, ok := params["max-age"]
+	if !ok {
+		return 0, nil
+	}
+
+	maxage, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, []Diagnostic{{header, "max-age", err}}
+	}
+	return maxage, nil
+}
+
 /*
    flag can either be:
    - Not present
@@ -198,6 +333,64 @@ func ParseXCTO(resp *http.Response) (p *XCTOProfile) {
 
 }
 
+/*
+   Possible values, see https://www.w3.org/TR/referrer-policy/:
+   - no-referrer
+   - no-referrer-when-downgrade (sends the full URL cross-origin)
+   - same-origin
+   - origin
+   - strict-origin
+   - origin-when-cross-origin
+   - strict-origin-when-cross-origin
+   - unsafe-url (sends the full URL everywhere, even over HTTP)
+   A comma separated list lets sites fall back for old browsers, the last value a browser recognises is used.
+*/
+func ParseReferrer(resp *http.Response) (p *ReferrerProfile) {
+	var policy string
+	for _, v := range strings.Split(strings.Join(resp.Header.Values("Referrer-Policy"), ","), ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		switch v {
+		case "no-referrer", "no-referrer-when-downgrade", "same-origin", "origin", "strict-origin",
+			"origin-when-cross-origin", "strict-origin-when-cross-origin", "unsafe-url":
+			policy = v
+		}
+	}
+
+	return &ReferrerProfile{hasHeader(resp, "Referrer-Policy"), policy}
+}
+
+/*
+   See https://html.spec.whatwg.org/multipage/browsers.html#cross-origin-opener-policies
+   and https://fetch.spec.whatwg.org/#cross-origin-resource-policy-header.
+   COOP and COEP are structured field tokens which may have parameters such as report-to.
+*/
+func ParseCrossOrigin(resp *http.Response) (p *CrossOriginProfile) {
+	token := func(header string, allowed ...string) string {
+		v := strings.ToLower(strings.TrimSpace(strings.SplitN(resp.Header.Get(header), ";", 2)[0]))
+		for _, a := range allowed {
+			if v == a {
+				return v
+			}
+		}
+		return ""
+	}
+
+	profile := CrossOriginProfile{
+		COOP: token("Cross-Origin-Opener-Policy", "same-origin", "same-origin-allow-popups", "noopener-allow-popups"),
+		COEP: token("Cross-Origin-Embedder-Policy", "require-corp", "credentialless"),
+		CORP: token("Cross-Origin-Resource-Policy", "same-origin", "same-site", "cross-origin"),
+	}
+	if profile.COOP == "" {
+		profile.COOP = "unsafe-none"
+	}
+	if profile.COEP == "" {
+		profile.COEP = "unsafe-none"
+	}
+	profile.Isolated = profile.COOP == "same-origin" && profile.COEP != "unsafe-none"
+
+	return &profile
+}
+
 func hasHeader(resp *http.Response, str string) (b bool) {
 	return (resp.Header.Get(str) != "")
 }
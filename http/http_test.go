@@ -17,11 +17,13 @@ var parseHPKPTests = []struct {
     {s: "max-age=5184000; pin-sha256=\"WoiWRyIOVNa9ihaBciRSC7XHjliYS9VwUGOIud4PB18=\"; includeSubDomains",
      p: &HPKPProfile{5184000, 
                     []string{`WoiWRyIOVNa9ihaBciRSC7XHjliYS9VwUGOIud4PB18=`}, 
-                    true}},
+                    true,
+                    nil}},
     {s: `pin-sha256="d6qzRu9zOECb90Uez27xWltNsj0e1Md7GkYYkVoZWmM="; pin-sha256="LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="; max-age=259200`,
      p: &HPKPProfile{259200, 
                     []string{`d6qzRu9zOECb90Uez27xWltNsj0e1Md7GkYYkVoZWmM=`, `LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=`}, 
-                    false}},
+                    false,
+                    nil}},
 }
 
 func TestParseHPKP(t *testing.T) {
@@ -46,10 +48,16 @@ var parseHSTSTests = []struct {
     {s: "max-age=31536000; includeSubdomains; preload",
      p: &HSTSProfile{31536000, 
                     true, 
-                    true}},
+                    true,
+                    nil,
+                    false,
+                    false}},
     {s: `max-age=631138519`,
      p: &HSTSProfile{631138519, 
                      false,
+                    false,
+                    nil,
+                    false,
                     false}},
 }
 
@@ -175,3 +183,158 @@ func TestParseXCTO(t *testing.T) {
 	}
 }
 
+
+func TestParseHSTSDiagnostics(t *testing.T) {
+    r := new(http.Response)
+    r.Header = make(http.Header)
+    r.Header.Set("Strict-Transport-Security", "max-age=1y; includeSubDomains")
+
+    p := ParseHSTS(r)
+    if p.Maxage != 0 || len(p.Diagnostics) != 1 || p.Diagnostics[0].Directive != "max-age" {
+        t.Errorf("HSTSProfile for a malformed max-age = %+v\n", p)
+    }
+    if s := ScoreHSTS(p); s != 0 {
+        t.Errorf("ScoreHSTS for a malformed max-age = %d, want 0", s)
+    }
+}
+
+var parseReferrerTests = []struct {
+    s     string
+    p     *ReferrerProfile
+    score int
+}{
+    {s: `no-referrer`,
+     p: &ReferrerProfile{true, "no-referrer"}, score: 4},
+    //the last policy the browser understands wins
+    {s: `no-referrer, strict-origin-when-cross-origin`,
+     p: &ReferrerProfile{true, "strict-origin-when-cross-origin"}, score: 3},
+    {s: `Origin, some-future-policy`,
+     p: &ReferrerProfile{true, "origin"}, score: 2},
+    {s: `unsafe-url`,
+     p: &ReferrerProfile{true, "unsafe-url"}, score: 0},
+    {s: `no-referrer-when-downgrade`,
+     p: &ReferrerProfile{true, "no-referrer-when-downgrade"}, score: 0},
+    //without a policy they recognise browsers apply strict-origin-when-cross-origin
+    {s: `nonsense`,
+     p: &ReferrerProfile{true, ""}, score: 2},
+    {s: ``,
+     p: &ReferrerProfile{false, ""}, score: 2},
+}
+
+func TestParseReferrer(t *testing.T) {
+    for _, tt := range parseReferrerTests {
+        r := new(http.Response)
+        r.Header = make(http.Header)
+        r.Header.Set("Referrer-Policy", tt.s)
+
+        p := ParseReferrer(r)
+        if !reflect.DeepEqual(tt.p, p) {
+            t.Errorf("ReferrerProfile for %q = %+v, want %+v", tt.s, p, tt.p)
+        }
+        if s := ScoreReferrer(p); s != tt.score {
+            t.Errorf("ScoreReferrer for %q = %d, want %d", tt.s, s, tt.score)
+        }
+    }
+}
+
+var parseCrossOriginTests = []struct {
+    coop, coep, corp string
+    p                *CrossOriginProfile
+    score            int
+}{
+    {coop: `same-origin`, coep: `require-corp; report-to="coep"`, corp: `same-origin`,
+     p: &CrossOriginProfile{"same-origin", "require-corp", "same-origin", true}, score: 6},
+    {coop: `same-origin-allow-popups`, corp: `same-site`,
+     p: &CrossOriginProfile{"same-origin-allow-popups", "unsafe-none", "same-site", false}, score: 2},
+    {coop: `Same-Origin`, coep: `credentialless`, corp: `cross-origin`,
+     p: &CrossOriginProfile{"same-origin", "credentialless", "cross-origin", true}, score: 4},
+    {coop: `sameorigin`,
+     p: &CrossOriginProfile{"unsafe-none", "unsafe-none", "", false}, score: 0},
+}
+
+func TestParseCrossOrigin(t *testing.T) {
+    for _, tt := range parseCrossOriginTests {
+        r := new(http.Response)
+        r.Header = make(http.Header)
+        r.Header.Set("Cross-Origin-Opener-Policy", tt.coop)
+        r.Header.Set("Cross-Origin-Embedder-Policy", tt.coep)
+        r.Header.Set("Cross-Origin-Resource-Policy", tt.corp)
+
+        p := ParseCrossOrigin(r)
+        if !reflect.DeepEqual(tt.p, p) {
+            t.Errorf("CrossOriginProfile for %q %q %q = %+v, want %+v", tt.coop, tt.coep, tt.corp, p, tt.p)
+        }
+        if s := ScoreCrossOrigin(p); s != tt.score {
+            t.Errorf("ScoreCrossOrigin for %q %q %q = %d, want %d", tt.coop, tt.coep, tt.corp, s, tt.score)
+        }
+    }
+}
+
+var scoreHSTSTests = []struct {
+    s     string
+    score int
+}{
+    {s: `max-age=0; includeSubDomains; preload`, score: 0},
+    {s: `max-age=86400; includeSubDomains; preload`, score: 1},
+    {s: `max-age=15768000; includeSubDomains; preload`, score: 3},
+    {s: `max-age=15768000`, score: 2},
+    {s: `max-age=31536000`, score: 3},
+    {s: `max-age=31536000; includeSubDomains`, score: 4},
+    {s: `max-age=63072000; includeSubDomains; preload`, score: 5},
+    //preload without includeSubDomains would be refused
+    {s: `max-age=63072000; preload`, score: 3},
+    {s: ``, score: 0},
+}
+
+func TestScoreHSTS(t *testing.T) {
+    for _, tt := range scoreHSTSTests {
+        r := new(http.Response)
+        r.Header = make(http.Header)
+        r.Header.Set("Strict-Transport-Security", tt.s)
+
+        if s := ScoreHSTS(ParseHSTS(r)); s != tt.score {
+            t.Errorf("ScoreHSTS for %q = %d, want %d", tt.s, s, tt.score)
+        }
+    }
+}
+
+func TestParseHSTSConflicting(t *testing.T) {
+    var conflictingTests = []struct {
+        headers     []string
+        conflicting bool
+        score       int
+    }{
+        {[]string{`max-age=63072000; includeSubDomains; preload`, `preload;includesubdomains;  MAX-AGE=63072000`}, false, 5},
+        {[]string{`max-age=63072000; includeSubDomains; preload`, `max-age=0`}, true, 4},
+    }
+
+    for _, tt := range conflictingTests {
+        r := new(http.Response)
+        r.Header = make(http.Header)
+        for _, h := range tt.headers {
+            r.Header.Add("Strict-Transport-Security", h)
+        }
+
+        p := ParseHSTS(r)
+        if p.Conflicting != tt.conflicting || p.Maxage != 63072000 {
+            t.Errorf("HSTSProfile for %q = %+v\n", tt.headers, p)
+        }
+        if s := ScoreHSTS(p); s != tt.score {
+            t.Errorf("ScoreHSTS for %q = %d, want %d", tt.headers, s, tt.score)
+        }
+    }
+}
+
+func TestParseHSTSOverHTTP(t *testing.T) {
+    for _, scheme := range []string{"http", "https"} {
+        r := new(http.Response)
+        r.Header = make(http.Header)
+        r.Header.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
+        r.Request, _ = http.NewRequest("GET", scheme+"://bank.com/", nil)
+
+        p := ParseHSTS(r)
+        if p.Ignored != (scheme == "http") || (ScoreHSTS(p) == 0) != p.Ignored {
+            t.Errorf("HSTSProfile over %s = %+v, scored %d\n", scheme, p, ScoreHSTS(p))
+        }
+    }
+}
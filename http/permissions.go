@@ -0,0 +1,189 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Permissions-Policy, or the Feature-Policy it replaced, see https://www.w3.org/TR/permissions-policy-1/
+type PermissionsProfile struct {
+	Present bool
+	Legacy  bool //only Feature-Policy was sent
+
+	//each feature's allowlist: "*", "self" or origins, empty if the feature is disabled
+	Features    map[string][]string
+	Diagnostics []Diagnostic
+}
+
+// Features a banking site has no business delegating to anyone
+var SensitiveFeatures = []string{"camera", "microphone", "geolocation", "payment", "usb", "display-capture"}
+
+var featureName = regexp.MustCompile("^[a-z*][a-z0-9_.*-]*$")
+
+func ParsePermissions(resp *http.Response) (p *PermissionsProfile) {
+	profile := PermissionsProfile{Features: make(map[string][]string)}
+
+	if v := resp.Header.Values("Permissions-Policy"); len(v) > 0 {
+		profile.Present = true
+		profile.parseDictionary(strings.Join(v, ","))
+	} else if v := resp.Header.Values("Feature-Policy"); len(v) > 0 {
+		profile.Present, profile.Legacy = true, true
+		profile.parseFeaturePolicy(strings.Join(v, ";"))
+	}
+
+	return &profile
+}
+
+/*
+   Permissions-Policy is a structured field dictionary, see https://tools.ietf.org/html/rfc8941 section 3.2,
+   of features to an inner list (or single item) of tokens and origin strings, e.g.
+   geolocation=(), camera=(self "https://video.example.com"), fullscreen=*
+*/
+func (p *PermissionsProfile) parseDictionary(v string) {
+	const header = "Permissions-Policy"
+
+	for _, member := range splitStructuredField(v, ',') {
+		member = strings.TrimSpace(splitStructuredField(member, ';')[0])
+		if member == "" {
+			continue
+		}
+
+		kv := strings.SplitN(member, "=", 2)
+		feature := strings.TrimSpace(kv[0])
+		if !featureName.MatchString(feature) {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{header, feature, errors.New("invalid feature name")})
+			continue
+		}
+		if len(kv) != 2 {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{header, feature, errors.New("missing allowlist")})
+			continue
+		}
+
+		value := strings.TrimSpace(kv[1])
+		if strings.HasPrefix(value, "(") {
+			if !strings.HasSuffix(value, ")") {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{header, feature, errors.New("unterminated inner list")})
+				continue
+			}
+			value = value[1 : len(value)-1]
+		}
+
+		allowlist := []string{}
+		valid := true
+		for _, item := range strings.Fields(value) {
+			switch {
+			case item == "*" || item == "self":
+				allowlist = append(allowlist, item)
+			case strings.HasPrefix(item, `"`) && strings.HasSuffix(item, `"`) && len(item) > 1:
+				allowlist = append(allowlist, item[1:len(item)-1])
+			default:
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{header, feature, errors.New("invalid allowlist item " + item)})
+				valid = false
+			}
+		}
+
+		//the last instance of a key in a dictionary wins
+		if valid {
+			p.Features[feature] = allowlist
+		}
+	}
+}
+
+/*
+   Feature-Policy is ";" separated, each a feature and a space separated allowlist of origins,
+   'self', 'src', 'none' or *, e.g. geolocation 'none'; camera 'self' https://video.example.com
+*/
+func (p *PermissionsProfile) parseFeaturePolicy(v string) {
+	const header = "Feature-Policy"
+
+	for _, directive := range strings.Split(v, ";") {
+		tokens := strings.Fields(directive)
+		if len(tokens) == 0 {
+			continue
+		}
+		feature := strings.ToLower(tokens[0])
+		if !featureName.MatchString(feature) {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{header, tokens[0], errors.New("invalid feature name")})
+			continue
+		}
+		if _, ok := p.Features[feature]; ok {
+			continue
+		}
+
+		allowlist := []string{}
+		for _, t := range tokens[1:] {
+			switch strings.ToLower(t) {
+			case "'none'":
+				allowlist = []string{}
+			case "'self'", "'src'":
+				allowlist = append(allowlist, "self")
+			case "*":
+				allowlist = append(allowlist, "*")
+			default:
+				if !strings.Contains(t, "://") {
+					p.Diagnostics = append(p.Diagnostics, Diagnostic{header, feature, errors.New("invalid allowlist item " + t)})
+					continue
+				}
+				allowlist = append(allowlist, t)
+			}
+			if strings.EqualFold(t, "'none'") {
+				break
+			}
+		}
+		p.Features[feature] = allowlist
+	}
+}
+
+// Whether any origin at all may use feature, a feature not in the policy gets its default of "self" or "*"
+func (p *PermissionsProfile) AllowsAll(feature string) bool {
+	allowlist, ok := p.Features[feature]
+	return ok && hasSource(allowlist, "*")
+}
+
+/*
+   A point for a policy, and for each of the SensitiveFeatures disabled or kept to the site's own origin.
+   Delegating one to every origin with * costs a point, as does each diagnostic.
+*/
+func ScorePermissions(p *PermissionsProfile) (s int) {
+	if !p.Present {
+		return 0
+	}
+
+	s = 1
+	for _, f := range SensitiveFeatures {
+		allowlist, ok := p.Features[f]
+		switch {
+		case !ok:
+		case p.AllowsAll(f):
+			s--
+		case len(allowlist) == 0 || (len(allowlist) == 1 && allowlist[0] == "self"):
+			s++
+		}
+	}
+
+	return penalise(s, p.Diagnostics)
+}
+
+// Splits a structured field on sep, except within its strings and inner lists (in parentheses)
+func splitStructuredField(v string, sep byte) (parts []string) {
+	quoted, depth, start := false, 0, 0
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, v[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, v[start:])
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+    "net/http"
+    "reflect"
+    "testing"
+)
+
+var parsePermissionsTests = []struct {
+    header string
+    s      string
+    p      *PermissionsProfile
+    score  int
+}{
+    {header: "Permissions-Policy",
+     s: `camera=(), microphone=(), geolocation=(self "https://maps.example.com"), payment=self, fullscreen=*`,
+     p: &PermissionsProfile{Present: true,
+                            Features: map[string][]string{"camera": {}, "microphone": {}, "geolocation": {"self", "https://maps.example.com"},
+                                                          "payment": {"self"}, "fullscreen": {"*"}}},
+     score: 4},
+    //parameters are ignored, and the last instance of a feature wins
+    {header: "Permissions-Policy",
+     s: `usb=();report-to=default, camera=(self), camera=*, Geolocation=(), interest-cohort=(nonsense)`,
+     p: &PermissionsProfile{Present: true,
+                            Features: map[string][]string{"usb": {}, "camera": {"*"}},
+                            Diagnostics: []Diagnostic{{"Permissions-Policy", "Geolocation", nil}, {"Permissions-Policy", "interest-cohort", nil}}},
+     score: 0},
+    {header: "Feature-Policy",
+     s: `camera 'none'; geolocation 'self' https://maps.example.com; microphone *; payment 'src'`,
+     p: &PermissionsProfile{Present: true, Legacy: true,
+                            Features: map[string][]string{"camera": {}, "geolocation": {"self", "https://maps.example.com"},
+                                                          "microphone": {"*"}, "payment": {"self"}}},
+     score: 2},
+    {header: "X-Unrelated", s: `camera=()`,
+     p: &PermissionsProfile{Features: map[string][]string{}}, score: 0},
+}
+
+func TestParsePermissions(t *testing.T) {
+    for _, tt := range parsePermissionsTests {
+        r := new(http.Response)
+        r.Header = make(http.Header)
+        r.Header.Set(tt.header, tt.s)
+
+        p := ParsePermissions(r)
+        //only compare where the diagnostics are
+        for i := range p.Diagnostics {
+            p.Diagnostics[i].Err = nil
+        }
+        if !reflect.DeepEqual(tt.p, p) {
+            t.Errorf("PermissionsProfile for %q = %+v\n want %+v\n", tt.s, p, tt.p)
+        }
+        if s := ScorePermissions(ParsePermissions(r)); s != tt.score {
+            t.Errorf("ScorePermissions for %q = %d, want %d", tt.s, s, tt.score)
+        }
+    }
+}
+
+func TestPermissionsPrecedence(t *testing.T) {
+    r := new(http.Response)
+    r.Header = make(http.Header)
+    r.Header.Set("Permissions-Policy", `camera=()`)
+    r.Header.Set("Feature-Policy", `camera *`)
+
+    if p := ParsePermissions(r); p.Legacy || p.AllowsAll("camera") {
+        t.Errorf("Feature-Policy was used over Permissions-Policy: %+v", p)
+    }
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+//go:generate go run gen_preload.go
+
+// A snapshot of the PreloadList, filtered to the entries which force HTTPS to keep it small
+//go:embed hsts_preload.json
+var preloadSnapshot []byte
+
+type PreloadEntry struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	Mode              string `json:"mode"` //"force-https", or "" for entries which only pin keys
+	IncludeSubdomains bool   `json:"include_subdomains"`
+}
+
+/*
+   Chromium's HSTS preload list, which Firefox, Safari and Edge also build theirs from, see https://hstspreload.org
+   It changes weekly, a newer copy of
+   https://chromium.googlesource.com/chromium/src/+/main/net/http/transport_security_state_static.json
+   can be loaded than the embedded snapshot.
+*/
+type PreloadList struct {
+	entries map[string]*PreloadEntry
+}
+
+var (
+	preloadOnce sync.Once
+	preload     *PreloadList
+)
+
+// The embedded snapshot
+func DefaultPreloadList() *PreloadList {
+	preloadOnce.Do(func() {
+		preload, _ = ParsePreloadList(preloadSnapshot)
+	})
+	return preload
+}
+
+// Loads a newer copy of transport_security_state_static.json
+func LoadPreloadList(path string) (*PreloadList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePreloadList(data)
+}
+
+// The list is JSON, apart from lines which are // comments
+func ParsePreloadList(data []byte) (*PreloadList, error) {
+	var stripped bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if !strings.HasPrefix(strings.TrimSpace(scanner.Text()), "//") {
+			stripped.Write(scanner.Bytes())
+		}
+		stripped.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Entries []*PreloadEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(stripped.Bytes(), &list); err != nil {
+		return nil, err
+	}
+
+	l := PreloadList{make(map[string]*PreloadEntry, len(list.Entries))}
+	for _, e := range list.Entries {
+		l.entries[strings.ToLower(e.Name)] = e
+	}
+	return &l, nil
+}
+
+/*
+   The entry forcing HTTPS on domain, either for domain itself or a parent with include_subdomains.
+   As in Chromium, the most specific entry wins, even if it doesn't force HTTPS.
+*/
+func (l *PreloadList) Lookup(domain string) *PreloadEntry {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(domain), "."), ".")
+	for i := range labels {
+		e, ok := l.entries[strings.Join(labels[i:], ".")]
+		if !ok || (i > 0 && !e.IncludeSubdomains) {
+			continue
+		}
+		if e.Mode != "force-https" {
+			return nil
+		}
+		return e
+	}
+	return nil
+}
+
+type PreloadProfile struct {
+	Domain    string
+	Preloaded bool          //browsers will only ever use HTTPS for the domain
+	Entry     *PreloadEntry //the entry doing so, which may be for a parent domain
+
+	Eligible bool     //meets the https://hstspreload.org submission requirements
+	Unmet    []string //the requirements which aren't met
+
+	FalseClaim bool //sends preload without being on the list
+}
+
+/*
+   Checks domain against the preload list and the submission requirements, given the HSTS header
+   of its HTTPS response and the response to a plain http:// request for it, which must redirect to HTTPS.
+   redirect may be nil if the HTTP request couldn't be made.
+   l may be nil to use the DefaultPreloadList.
+*/
+func CheckPreload(l *PreloadList, domain string, p *HSTSProfile, redirect *http.Response) *PreloadProfile {
+	if l == nil {
+		l = DefaultPreloadList()
+	}
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	profile := PreloadProfile{Domain: domain, Entry: l.Lookup(domain)}
+	profile.Preloaded = profile.Entry != nil
+
+	if p.Maxage < hstsGoodMaxage {
+		profile.Unmet = append(profile.Unmet, "max-age must be at least 31536000")
+	}
+	if !p.IncludeSubdomains {
+		profile.Unmet = append(profile.Unmet, "includeSubDomains is missing")
+	}
+	if !p.Preload {
+		profile.Unmet = append(profile.Unmet, "preload is missing")
+	}
+	if len(p.Diagnostics) > 0 {
+		profile.Unmet = append(profile.Unmet, "the header is malformed")
+	}
+	if p.Ignored {
+		profile.Unmet = append(profile.Unmet, "the header must be sent over HTTPS")
+	}
+	if !redirectsToHTTPS(redirect, domain) {
+		profile.Unmet = append(profile.Unmet, "http://"+domain+" must redirect to https://"+domain)
+	}
+	profile.Eligible = len(profile.Unmet) == 0
+
+	profile.FalseClaim = p.Preload && !profile.Preloaded
+
+	return &profile
+}
+
+// The first redirect must stay on the same host, so that its HSTS header is seen
+func redirectsToHTTPS(resp *http.Response, domain string) bool {
+	if resp == nil || resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return false
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return false
+	}
+	return location.Scheme == "https" && strings.EqualFold(location.Hostname(), domain)
+}
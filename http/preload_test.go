@@ -0,0 +1,113 @@
+package http
+
+import (
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+const testPreloadList = `{
+  // comments are allowed
+  "entries": [
+    { "name": "bank.com", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "pinned.bank.com", "policy": "custom", "pins": "bank" },
+    { "name": "mybank.co.uk", "policy": "bulk-18-weeks", "mode": "force-https" }
+  ]
+}`
+
+var lookupTests = []struct {
+    domain string
+    entry  string
+}{
+    {"bank.com", "bank.com"},
+    {"WWW.Bank.com.", "bank.com"},
+    //the most specific entry wins, even if it only pins
+    {"pinned.bank.com", ""},
+    {"a.pinned.bank.com", "bank.com"},
+    {"mybank.co.uk", "mybank.co.uk"},
+    {"www.mybank.co.uk", ""},
+    {"otherbank.com", ""},
+}
+
+func TestPreloadLookup(t *testing.T) {
+    l, err := ParsePreloadList([]byte(testPreloadList))
+    if err != nil {
+        t.Fatalf("ParsePreloadList returned %v", err)
+    }
+    for _, tt := range lookupTests {
+        entry := ""
+        if e := l.Lookup(tt.domain); e != nil {
+            entry = e.Name
+        }
+        if entry != tt.entry {
+            t.Errorf("Lookup(%q) = %q, want %q", tt.domain, entry, tt.entry)
+        }
+    }
+}
+
+func TestDefaultPreloadList(t *testing.T) {
+    l := DefaultPreloadList()
+    if l == nil || l.Lookup("www.paypal.com") == nil || l.Lookup("example.dev") == nil {
+        t.Errorf("the embedded preload list didn't parse")
+    }
+}
+
+func TestLoadPreloadList(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "transport_security_state_static.json")
+    os.WriteFile(path, []byte(testPreloadList), 0644)
+
+    if l, err := LoadPreloadList(path); err != nil || l.Lookup("bank.com") == nil {
+        t.Errorf("LoadPreloadList returned %v", err)
+    }
+    if _, err := LoadPreloadList(path + ".missing"); err == nil {
+        t.Errorf("LoadPreloadList of a missing file succeeded")
+    }
+}
+
+func redirectTo(status int, location string) *http.Response {
+    r := new(http.Response)
+    r.StatusCode = status
+    r.Header = make(http.Header)
+    r.Header.Set("Location", location)
+    return r
+}
+
+var checkPreloadTests = []struct {
+    domain   string
+    hsts     *HSTSProfile
+    redirect *http.Response
+
+    preloaded, eligible, falseClaim bool
+    unmet                           int
+}{
+    {"bank.com", &HSTSProfile{63072000, true, true, nil, false, false}, redirectTo(301, "https://bank.com/"),
+     true, true, false, 0},
+    //listed, but would no longer be accepted
+    {"mybank.co.uk", &HSTSProfile{15768000, false, false, nil, false, false}, redirectTo(302, "https://www.mybank.co.uk/"),
+     true, false, false, 4},
+    {"otherbank.com", &HSTSProfile{31536000, true, true, nil, false, false}, redirectTo(301, "https://otherbank.com/login"),
+     false, true, true, 0},
+    {"otherbank.com", &HSTSProfile{31536000, true, true, nil, false, false}, redirectTo(200, ""),
+     false, false, true, 1},
+    {"otherbank.com", &HSTSProfile{31536000, true, true, nil, false, false}, nil,
+     false, false, true, 1},
+    {"otherbank.com", &HSTSProfile{0, false, false, nil, false, false}, redirectTo(301, "http://otherbank.com/"),
+     false, false, false, 4},
+}
+
+func TestCheckPreload(t *testing.T) {
+    l, _ := ParsePreloadList([]byte(testPreloadList))
+    for _, tt := range checkPreloadTests {
+        p := CheckPreload(l, tt.domain, tt.hsts, tt.redirect)
+        if p.Preloaded != tt.preloaded || p.Eligible != tt.eligible || p.FalseClaim != tt.falseClaim || len(p.Unmet) != tt.unmet {
+            t.Errorf("CheckPreload for %q with %+v = %+v", tt.domain, tt.hsts, p)
+        }
+    }
+
+    //without a list the embedded snapshot is used
+    hsts := &HSTSProfile{63072000, true, true, nil, false, false}
+    if p := CheckPreload(nil, "www.paypal.com", hsts, redirectTo(301, "https://www.paypal.com/")); !p.Preloaded || p.FalseClaim || !p.Eligible {
+        t.Errorf("CheckPreload without a list = %+v", p)
+    }
+}